@@ -12,8 +12,16 @@
 package util
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 )
 
@@ -21,15 +29,55 @@ import (
 //                             Command Execution
 //============================================================================
 
+// DefaultGracePeriod is the time allowed between asking a command to
+// terminate (SIGTERM) and forcibly killing it (SIGKILL). If the command
+// runs in its own process group, the whole group is signalled.
+var DefaultGracePeriod = 5 * time.Second
+
+// ErrTimeout is returned when a command was killed because its timeout
+// (or its context's deadline) expired rather than failing on its own.
+var ErrTimeout = errors.New("command killed: timeout expired")
+
 // os.Exec contains further details
 type ExecCmd struct {
+	attempts	[]*ExecResult		// Results of the last run's attempts
+	audit		*AuditLog			// nil == options' or none
+	cache		*ExecCache			// nil == always run
+	cacheEnv	[]string			// Variables in the cache key
+	cacheInputs	[]*Path				// Files in the cache key
+	cacheOutputs	[]*Path			// Files restored from the cache
 	cmd       	*exec.Cmd
+	env			map[string]string	// Environment being built, nil == none
+	fwdSignals	bool				// Forward our signals while running
+	grace		time.Duration		// Time between SIGTERM and SIGKILL
+	limits		*ResourceLimits		// nil == none
+	opts		*ExecOptions		// Debug/Noop handling, nil == none
+	runner		CommandRunner		// nil == options' or DefaultRunner
+	pipeIn		*os.File			// stdin when in the middle of a Pipeline
+	pipeOut		*os.File			// stdout when in the middle of a Pipeline
+	procGroup	bool				// Run in a new process group
+	result		*ExecResult			// Result of the last execution
+	retry		*RetryPolicy		// nil == only attempt once
+	stdin		func() (io.Reader, func(), error)	// Opens stdin, nil == none
+	stderrLines	[]func(string)		// stderr line handlers
+	stderrTees	[]io.Writer			// stderr copies
+	stdoutLines	[]func(string)		// stdout line handlers
+	stdoutTees	[]io.Writer			// stdout copies
+	teePaths	[]*Path				// Combined output log files
+	timeout		time.Duration		// Maximum run time, 0 == none
+}
+
+// Args returns a copy of the program and arguments to be executed.
+func (c *ExecCmd) Args( ) []string {
+	return append([]string{}, c.cmd.Args...)
 }
 
 func (c *ExecCmd) Cmd( ) *exec.Cmd {
 	return c.cmd
 }
 
+// CommandString returns the program and its arguments quoted so that
+// they can be pasted into a POSIX shell.
 func (c *ExecCmd) CommandString( ) string {
 	if c.cmd == nil {
 		return ""
@@ -42,56 +90,361 @@ func (c *ExecCmd) CommandString( ) string {
 	return strings.Join(a, " ")
 }
 
-func (c *ExecCmd) SetCommandString(cmd string) {
+// SetCommandString parses the given command line (see ParseCommandLine)
+// and makes it the program and arguments to be executed.
+func (c *ExecCmd) SetCommandString(cmd string) error {
 	args, err := ParseCommandLine(cmd)
-	if err == nil {
-		c.cmd.Args = args
+	if err != nil {
+		return err
 	}
+	if len(args) == 0 {
+		return errors.New("Error: SetCommandString: no command was given")
+	}
+	// A new exec.Cmd is needed so that the old program's lookup error
+	// is forgotten.
+	old := c.cmd
+	c.cmd = exec.Command(args[0], args[1:]...)
+	c.cmd.Dir = old.Dir
+	c.cmd.Env = old.Env
+	c.cmd.Stdin = old.Stdin
+	c.cmd.Stdout = old.Stdout
+	c.cmd.Stderr = old.Stderr
+	c.cmd.ExtraFiles = old.ExtraFiles
+	c.cmd.SysProcAttr = old.SysProcAttr
+	return nil
 }
 
+// ExitCode returns the exit code of the last execution or -1 if the
+// command has not been run or was killed.
 func (c *ExecCmd) ExitCode( ) int {
+	if c.result != nil {
+		return c.result.ExitCode
+	}
 	if (c.cmd == nil) || (c.cmd.ProcessState == nil) {
 		return -1
 	}
 	return c.cmd.ProcessState.ExitCode()
 }
 
+// Result returns the result of the last execution or nil if the
+// command has not been run.
+func (c *ExecCmd) Result( ) *ExecResult {
+	return c.result
+}
+
+// QuoteArgIfNeeded returns the n'th argument quoted for a POSIX shell
+// (see QuoteArgPosix) so that ParseCommandLine(CommandString()) gives
+// back the original arguments.
 func (c *ExecCmd) QuoteArgIfNeeded(n int) string {
-	var s		string
+	return QuoteArgPosix(c.cmd.Args[n])
+}
 
-	s = c.cmd.Args[n]
-	if strings.Contains(s, " ") || strings.Contains(s, "\"") {
-		s = strings.Replace(s, `"`, `\"`, -1)
-		return `"` + s + `"`
+// GracePeriod returns the time allowed between SIGTERM and SIGKILL when
+// the command is being terminated.
+func (c *ExecCmd) GracePeriod( ) time.Duration {
+	if c.grace > 0 {
+		return c.grace
 	}
-	return s
+	return DefaultGracePeriod
+}
+
+// SetGracePeriod sets the time allowed between SIGTERM and SIGKILL when
+// the command is being terminated. Zero selects DefaultGracePeriod.
+func (c *ExecCmd) SetGracePeriod(d time.Duration) *ExecCmd {
+	c.grace = d
+	return c
+}
+
+// Timeout returns the maximum time that the command is allowed to run.
+// Zero means that there is no limit.
+func (c *ExecCmd) Timeout( ) time.Duration {
+	return c.timeout
+}
+
+// SetTimeout sets the maximum time that the command is allowed to run
+// before it is terminated. Zero means that there is no limit.
+func (c *ExecCmd) SetTimeout(d time.Duration) *ExecCmd {
+	c.timeout = d
+	return c
 }
 
 // Run runs the previously set up command.
 func (c *ExecCmd) Run( ) error {
+	return c.RunContext(context.Background())
+}
+
+// RunContext runs the previously set up command. If the context is done
+// or the command's timeout expires before the command completes, the
+// command is sent SIGTERM and then, after the grace period, SIGKILL.
+// ErrTimeout is returned if a timeout or deadline expired, otherwise
+// the context's error is returned.
+func (c *ExecCmd) RunContext(ctx context.Context) error {
 	var err		error
 
-	err = c.cmd.Run()
+	_, err = c.RunResultContext(ctx)
 
 	return err
 }
 
+// RunResult runs the previously set up command and returns everything
+// known about its execution. The result is returned even if an error
+// occurs so that any partial output can be examined.
+func (c *ExecCmd) RunResult( ) (*ExecResult, error) {
+	return c.RunResultContext(context.Background())
+}
+
+// RunResultContext is RunResult with the termination rules
+// of RunContext.
+func (c *ExecCmd) RunResultContext(ctx context.Context) (*ExecResult, error) {
+	r := c.executeWithRetry(ctx, nil)
+	return r, r.Err
+}
+
 // RunWithOutput runs the previously set up command, gets the combined output
 // of sysout and syserr, trims whitespace from it and returns if error free.
 // If any error occurs, it is simply returned.
 func (c *ExecCmd) RunWithOutput( ) (string, error) {
-	var err		error
+	return c.RunWithOutputContext(context.Background())
+}
 
-	outBytes, err := c.cmd.CombinedOutput()
-	if err != nil {
-		return "", err
+// RunWithOutputContext is RunWithOutput with the termination rules
+// of RunContext.
+func (c *ExecCmd) RunWithOutputContext(ctx context.Context) (string, error) {
+	var out		bytes.Buffer
+
+	r := c.executeWithRetry(ctx, &out)
+	if r.Err != nil {
+		return "", r.Err
 	}
-	s := string(outBytes)
+	s := out.String()
 	s = strings.TrimSpace(s)
 
 	return s, nil
 }
 
+// execute runs the command once and collects its result. Any writers
+// already attached to the command still receive its output. If
+// combined is not nil, stdout and stderr are also interleaved into it.
+func (c *ExecCmd) execute(ctx context.Context, combined io.Writer) *ExecResult {
+	var err		error
+	var stdout	bytes.Buffer
+	var stderr	bytes.Buffer
+
+	if (c.opts != nil) && !c.opts.before(c) {
+		r := &ExecResult{}
+		r.Args = append([]string{}, c.cmd.Args...)
+		r.DryRun = true
+		r.StartTime = time.Now()
+		r.EndTime = r.StartTime
+		c.result = r
+		c.record(r)
+		return r
+	}
+
+	outs := []io.Writer{&stdout}
+	errs := []io.Writer{&stderr}
+	if c.cmd.Stdout != nil {
+		outs = append(outs, c.cmd.Stdout)
+	}
+	if c.cmd.Stderr != nil {
+		errs = append(errs, c.cmd.Stderr)
+	}
+	if combined != nil {
+		lw := &lockedWriter{w: combined}
+		outs = append(outs, lw)
+		errs = append(errs, lw)
+	}
+	outs, errs, finish, err := c.streamWriters(outs, errs)
+	var outW io.Writer = io.MultiWriter(outs...)
+	if c.pipeOut != nil {
+		outW = c.pipeOut
+	}
+
+	r := &ExecResult{}
+	r.Args = append([]string{}, c.cmd.Args...)
+	r.ExitCode = -1
+	r.StartTime = time.Now()
+	if err == nil {
+		c.cmd.Process, c.cmd.ProcessState = nil, nil
+		if c.cache != nil {
+			r.ExitCode, r.Cached, err = c.cache.run(ctx, c, outW, io.MultiWriter(errs...))
+		} else {
+			r.ExitCode, err = c.Runner().RunCommand(ctx, c, outW, io.MultiWriter(errs...))
+		}
+		finish()
+	}
+	r.EndTime = time.Now()
+	r.Duration = r.EndTime.Sub(r.StartTime)
+	r.Stdout = stdout.Bytes()
+	r.Stderr = stderr.Bytes()
+	if c.cmd.ProcessState != nil {
+		r.Signal = exitSignal(c.cmd.ProcessState)
+		r.Usage = newResourceUsage(c.cmd.ProcessState)
+	}
+	if err != nil {
+		err = c.newExecError(r, err)
+	}
+	r.Err = err
+	c.result = r
+	c.record(r)
+
+	return r
+}
+
+// newOSCmd returns a copy of the command's exec.Cmd which has not
+// been started so that the command can be run more than once.
+func (c *ExecCmd) newOSCmd( ) *exec.Cmd {
+	cmd := *c.cmd
+	cmd.Process = nil
+	cmd.ProcessState = nil
+	if c.procGroup {
+		setProcessGroup(&cmd)
+	}
+	return &cmd
+}
+
+// terminate asks the running command to end and kills it if it has
+// not ended within the grace period. done must deliver the result
+// of Wait().
+func (c *ExecCmd) terminate(cmd *exec.Cmd, done <-chan error) error {
+	var err		error
+
+	if c.signal(cmd, terminateSignal) != nil {
+		// Some systems can not deliver SIGTERM.
+		c.kill(cmd)
+	}
+	timer := time.NewTimer(c.GracePeriod())
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		c.kill(cmd)
+		err = <-done
+	}
+
+	return err
+}
+
+// wait waits for the started command to complete, terminating it if
+// the context is done or the timeout expires first.
+func (c *ExecCmd) wait(ctx context.Context, cmd *exec.Cmd) error {
+	var cancel	context.CancelFunc
+	var err		error
+
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	c.terminate(cmd, done)
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
+
+// outputDrainTime is how long the output still in the pipes is copied
+// once a terminated command has ended before the pipes are abandoned.
+var outputDrainTime = 100 * time.Millisecond
+
+// outputPipes copies a command's output through pipes created here
+// rather than by exec.Cmd. exec.Cmd waits until every process holding
+// its pipes has closed them, so a process started by the command that
+// outlives it would otherwise keep a terminated command waiting.
+type outputPipes struct {
+	readers		[]*os.File
+	writers		[]*os.File
+	done		chan struct{}		// Closed when the copying has finished
+}
+
+// newOutputPipes connects the command's stdout and stderr to the
+// writers. A writer that is a file (or nil) is given to the command
+// directly.
+func newOutputPipes(cmd *exec.Cmd, stdout, stderr io.Writer) (*outputPipes, error) {
+	var dsts	[]io.Writer
+
+	o := &outputPipes{done: make(chan struct{})}
+	for _, out := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		w := stdout
+		if out == &cmd.Stderr {
+			w = stderr
+		}
+		if _, ok := w.(*os.File); ok || (w == nil) {
+			*out = w
+			continue
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			o.close()
+			return nil, err
+		}
+		o.readers = append(o.readers, pr)
+		o.writers = append(o.writers, pw)
+		dsts = append(dsts, w)
+		*out = pw
+	}
+
+	var wg		sync.WaitGroup
+	for i, r := range o.readers {
+		wg.Add(1)
+		go func(dst io.Writer, src *os.File) {
+			io.Copy(dst, src)
+			wg.Done()
+		}(dsts[i], r)
+	}
+	go func() {
+		wg.Wait()
+		close(o.done)
+	}()
+	return o, nil
+}
+
+// close closes both ends of the pipes abandoning any output not yet
+// copied.
+func (o *outputPipes) close( ) {
+	o.closeWriters()
+	for _, f := range o.readers {
+		f.Close()
+	}
+}
+
+// closeWriters closes our copies of the ends given to the command which
+// must be done once it has been started (or failed to start).
+func (o *outputPipes) closeWriters( ) {
+	for _, f := range o.writers {
+		f.Close()
+	}
+	o.writers = nil
+}
+
+// wait waits for the output to be copied. If the command was terminated,
+// the output is only copied for outputDrainTime.
+func (o *outputPipes) wait(terminated bool) {
+	if terminated {
+		timer := time.NewTimer(outputDrainTime)
+		defer timer.Stop()
+		select {
+		case <-o.done:
+		case <-timer.C:
+			o.close()
+			<-o.done
+		}
+	}
+	<-o.done
+	o.close()
+}
+
 //----------------------------------------------------------------------------
 //							Class Functions
 //----------------------------------------------------------------------------
@@ -108,50 +461,111 @@ func NewExecArgs(name string, args... string) *ExecCmd {
 	return &ce
 }
 
-/*   ParseCommand parses the program command line breaking it
-     up into substrings forming an argv/argc structure. Arguments are
-     separated by whitespace. An AStrC Array is used for argv/argc.
-     We don't have to completely parse the command line, just break
-     it up being coznizant of the quoted strings which assumes that
-     they should either be by themselves or at the end of an argument.
-     Note: we currently do not handle embedded quotes.
+//----------------------------------------------------------------------------
+//							Command Line Parsing
+//----------------------------------------------------------------------------
+
+// CommandLineError describes why a command line could not be parsed
+// and where the problem was found.
+type CommandLineError struct {
+	Loc			Location
+	Msg			string
+}
+
+func (e *CommandLineError) Error( ) string {
+	return fmt.Sprintf("Error: %s at line %d column %d", e.Msg, e.Loc.LineNo, e.Loc.ColNo)
+}
+
+/*   ParseCommandLine parses the program command line breaking it
+     up into substrings forming an argv/argc structure using the
+     POSIX shell word splitting rules:
+       - Arguments are separated by unquoted whitespace.
+       - A backslash outside of quotes preserves the next character
+         except that a backslash-newline is removed entirely.
+       - Single quotes preserve everything up to the next single quote.
+       - Double quotes preserve everything up to the next double quote
+         except that a backslash escapes $, `, ", \ and newline.
+       - Adjacent quoted and unquoted segments form one argument.
+     No expansions of any kind are performed. An unterminated quote
+     or a trailing backslash returns a *CommandLineError giving the
+     line and column (both 1-relative) where it began.
  */
 func ParseCommandLine(cmd string) ([]string, error) {
 	var args	[]string
+	var word	[]rune
+	var inWord	bool
 	var i		int
 
 	cmdRunes := []rune(cmd)
 	iMax := len(cmdRunes)
 
-	for i=0; i<iMax; {
-		// Skip leading spaces.
-		for (i < iMax) && unicode.IsSpace(cmdRunes[i]) {
-			i++
+	// Compute the location of a rune in the command line.
+	location := func(pos int) Location {
+		loc := Location{Pos: pos, LineNo: 1, ColNo: 1}
+		for j := 0; j < pos; j++ {
+			if cmdRunes[j] == '\n' {
+				loc.LineNo++
+				loc.ColNo = 1
+			} else {
+				loc.ColNo++
+			}
 		}
-		// Scan off an argument.
-		if (i < iMax) {
+		return loc
+	}
+
+	for i = 0; i < iMax; i++ {
+		r := cmdRunes[i]
+		switch {
+		case r == '\\':
+			if i+1 >= iMax {
+				return nil, &CommandLineError{Loc: location(i), Msg: "trailing backslash"}
+			}
+			i++
+			if cmdRunes[i] != '\n' {
+				word = append(word, cmdRunes[i])
+				inWord = true
+			}
+		case r == '\'':
 			start := i
-			for (i < iMax) && !unicode.IsSpace(cmdRunes[i]) {
-				if (cmdRunes[i] == '"') || (cmdRunes[i] == '\'') {
-					quote := cmdRunes[i]; i++
-					for (i < iMax) && !(cmdRunes[i] == quote) {
+			for i++; (i < iMax) && (cmdRunes[i] != '\''); i++ {
+				word = append(word, cmdRunes[i])
+			}
+			if i >= iMax {
+				return nil, &CommandLineError{Loc: location(start), Msg: "unterminated single quote"}
+			}
+			inWord = true
+		case r == '"':
+			start := i
+			for i++; (i < iMax) && (cmdRunes[i] != '"'); i++ {
+				if (cmdRunes[i] == '\\') && (i+1 < iMax) {
+					switch cmdRunes[i+1] {
+					case '$', '`', '"', '\\':
+						i++
+					case '\n':
 						i++
+						continue
 					}
 				}
-				if !(i < iMax) || unicode.IsSpace(cmdRunes[i]) {
-					break
-				}
-				i++
+				word = append(word, cmdRunes[i])
 			}
-			if (start < iMax) && (start <= iMax) {
-				args= append(args, string(cmdRunes[start:i]))
+			if i >= iMax {
+				return nil, &CommandLineError{Loc: location(start), Msg: "unterminated double quote"}
 			}
-			i++
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				args = append(args, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		default:
+			word = append(word, r)
+			inWord = true
 		}
 	}
+	if inWord {
+		args = append(args, string(word))
+	}
 
 	return args, nil
 }
-
-
-
@@ -0,0 +1,231 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Audit Log
+
+// An AuditLog keeps a record of every command executed so that what a
+// tool did can be reviewed and reproduced. Each execution (including
+// each retry, dry run and cached replay) is written as one JSON object
+// per line. Values of environment variables whose names match the
+// redaction patterns are replaced before being written. An AuditLog is
+// attached to individual commands or, more usually, to the ExecOptions
+// shared by all of a program's commands.
+
+package util
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+//============================================================================
+//                             Audit Record
+//============================================================================
+
+// AuditRecord describes one execution of a command.
+type AuditRecord struct {
+	Args		[]string		`json:"args"`
+	Dir			string			`json:"dir"`
+	Env			[]string		`json:"env"`				// Redacted and sorted
+	Start		time.Time		`json:"start"`
+	Duration	time.Duration	`json:"duration"`			// Nanoseconds
+	ExitCode	int				`json:"exit_code"`
+	Signal		string			`json:"signal,omitempty"`
+	StdoutBytes	int				`json:"stdout_bytes"`
+	StderrBytes	int				`json:"stderr_bytes"`
+	DryRun		bool			`json:"dry_run,omitempty"`
+	Cached		bool			`json:"cached,omitempty"`
+	Error		string			`json:"error,omitempty"`
+	Usage		*ResourceUsage	`json:"usage,omitempty"`
+}
+
+//============================================================================
+//                             Audit Log
+//============================================================================
+
+// AuditLog writes AuditRecords. It is safe for use by several goroutines
+// at once.
+type AuditLog struct {
+	mu			sync.Mutex
+	closer		io.Closer			// nil == do not close
+	err			error				// First write error
+	redact		[]*regexp.Regexp	// nil == EnvRedactPattern
+	w			io.Writer
+}
+
+// Close closes the log's file if it opened one.
+func (a *AuditLog) Close( ) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closer == nil {
+		return nil
+	}
+	err := a.closer.Close()
+	a.closer = nil
+	return err
+}
+
+// Err returns the first error that occurred writing the log. Commands
+// do not fail because their execution could not be logged.
+func (a *AuditLog) Err( ) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Record writes the record as a line of JSON.
+func (a *AuditLog) Record(r *AuditRecord) error {
+	text, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	text = append(text, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err = a.w.Write(text); (err != nil) && (a.err == nil) {
+		a.err = err
+	}
+	return err
+}
+
+// RedactEnv returns a copy of the "key=value" environment with the
+// values of keys matching any of the log's patterns replaced.
+func (a *AuditLog) RedactEnv(env []string) []string {
+	a.mu.Lock()
+	patterns := a.redact
+	a.mu.Unlock()
+	if len(patterns) == 0 {
+		return RedactEnv(env, nil)
+	}
+	for _, re := range patterns {
+		env = RedactEnv(env, re)
+	}
+	return env
+}
+
+// SetRedactPatterns sets the patterns matching the names of environment
+// variables whose values are not logged. None selects EnvRedactPattern.
+func (a *AuditLog) SetRedactPatterns(patterns ...*regexp.Regexp) *AuditLog {
+	a.mu.Lock()
+	a.redact = patterns
+	a.mu.Unlock()
+	return a
+}
+
+// newAuditRecord returns the record of the command's execution.
+func (a *AuditLog) newAuditRecord(c *ExecCmd, r *ExecResult) *AuditRecord {
+	ar := &AuditRecord{}
+	ar.Args = r.Args
+	ar.Dir = c.WorkDir()
+	if ar.Dir == "" {
+		ar.Dir = NewCurrentWorkDir().Absolute()
+	}
+	ar.Env = a.RedactEnv(c.Environ())
+	ar.Start = r.StartTime
+	ar.Duration = r.Duration
+	ar.ExitCode = r.ExitCode
+	ar.Signal = r.Signal
+	ar.StdoutBytes = len(r.Stdout)
+	ar.StderrBytes = len(r.Stderr)
+	ar.DryRun = r.DryRun
+	ar.Cached = r.Cached
+	if r.Err != nil {
+		ar.Error = r.Err.Error()
+	}
+	ar.Usage = r.Usage
+	return ar
+}
+
+// NewAuditLog returns a log writing to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// NewAuditLogPath returns a log appending to the file at the given path
+// which is created if needed. The log should be closed when done.
+func NewAuditLogPath(p *Path) (*AuditLog, error) {
+	f, err := os.OpenFile(p.Absolute(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{w: f, closer: f}, nil
+}
+
+// ReadAuditLog reads the records written to an audit log.
+func ReadAuditLog(r io.Reader) ([]*AuditRecord, error) {
+	var records	[]*AuditRecord
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		ar := &AuditRecord{}
+		if err := json.Unmarshal(s.Bytes(), ar); err != nil {
+			return records, fmt.Errorf("Error: audit log line %d: %s", lineNo, err.Error())
+		}
+		records = append(records, ar)
+	}
+	return records, s.Err()
+}
+
+// ReadAuditLogPath reads the records of the audit log at the given path.
+func ReadAuditLogPath(p *Path) ([]*AuditRecord, error) {
+	f, err := os.Open(p.Absolute())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadAuditLog(f)
+}
+
+//============================================================================
+//                             Command Auditing
+//============================================================================
+
+// AuditLog returns the audit log of the command, which is its own or
+// its options', or nil if there is none.
+func (c *ExecCmd) AuditLog( ) *AuditLog {
+	if c.audit != nil {
+		return c.audit
+	}
+	if c.opts != nil {
+		return c.opts.AuditLog()
+	}
+	return nil
+}
+
+// SetAuditLog sets the log recording each execution of the command.
+func (c *ExecCmd) SetAuditLog(a *AuditLog) *ExecCmd {
+	c.audit = a
+	return c
+}
+
+// record writes the result to the command's audit log if it has one.
+func (c *ExecCmd) record(r *ExecResult) {
+	if a := c.AuditLog(); a != nil {
+		a.Record(a.newAuditRecord(c, r))
+	}
+}
+
+// AuditLog returns the audit log of commands with these options or nil.
+func (o *ExecOptions) AuditLog( ) *AuditLog {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.audit
+}
+
+// SetAuditLog sets the audit log of commands with these options.
+func (o *ExecOptions) SetAuditLog(a *AuditLog) *ExecOptions {
+	o.mu.Lock()
+	o.audit = a
+	o.mu.Unlock()
+	return o
+}
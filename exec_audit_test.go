@@ -0,0 +1,82 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf		bytes.Buffer
+
+	t.Log("TestAuditLog()")
+
+	a := NewAuditLog(&buf)
+	opts := NewExecOptions().SetAuditLog(a)
+	NewExecArgs("sh", "-c", "printf 12345; printf ab >&2").SetOptions(opts).
+			SetEnv("DB_PASSWORD", "hunter2").SetEnv("MY_SETTING", "visible").Run()
+	NewExecArgs("sh", "-c", "exit 3").SetOptions(opts).Dir(NewPath("./test")).Run()
+	opts.SetNoop(true).SetQuiet(true)
+	NewExecArgs("rm", "-rf", "/nothing").SetOptions(opts).Run()
+
+	text := buf.String()
+	if strings.Count(text, "\n") != 3 || strings.Contains(text, "hunter2") {
+		t.Fatalf("log:\n%s\n", text)
+	}
+	records, err := ReadAuditLog(strings.NewReader(text))
+	if err != nil || len(records) != 3 {
+		t.Fatalf("ReadAuditLog(): %d %v\n", len(records), err)
+	}
+	r := records[0]
+	if !equalArgs(r.Args, []string{"sh", "-c", "printf 12345; printf ab >&2"}) || r.ExitCode != 0 ||
+			r.StdoutBytes != 5 || r.StderrBytes != 2 || r.Error != "" {
+		t.Errorf("record 0: %#v\n", r)
+	}
+	if r.Dir != NewCurrentWorkDir().Absolute() || r.Start.IsZero() || r.Duration <= 0 {
+		t.Errorf("record 0 timing: %s %s %s\n", r.Dir, r.Start, r.Duration)
+	}
+	env := strings.Join(r.Env, "\n")
+	if !strings.Contains(env, "DB_PASSWORD=" + RedactedValue) || !strings.Contains(env, "MY_SETTING=visible") {
+		t.Errorf("record 0 env: %q\n", r.Env)
+	}
+	if r = records[1]; r.ExitCode != 3 || r.Error == "" || r.Dir != NewPath("./test").Absolute() {
+		t.Errorf("record 1: %#v\n", r)
+	}
+	if r = records[2]; !r.DryRun || r.Args[0] != "rm" {
+		t.Errorf("record 2: %#v\n", r)
+	}
+
+	// The redaction patterns can be replaced and the log can be a file.
+	file := NewTempDir().Append("exec_audit_test.jsonl")
+	file.DeleteFile()
+	defer file.DeleteFile()
+	a, err = NewAuditLogPath(file)
+	if err != nil {
+		t.Fatalf("NewAuditLogPath() failed: %s\n", err.Error())
+	}
+	a.SetRedactPatterns(regexp.MustCompile(`^MY_`), regexp.MustCompile(`(?i)secret`))
+	cmd := NewExecArgs("false").SetAuditLog(a).SetEnv("MY_SETTING", "hidden").SetEnv("app_secret", "x")
+	cmd.SetRetryPolicy(NewRetryPolicy(2, time.Millisecond)).Run()
+	a.Close()
+	records, err = ReadAuditLogPath(file)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("ReadAuditLogPath(): %d %v\n", len(records), err)
+	}
+	env = strings.Join(records[0].Env, "\n")
+	if strings.Contains(env, "hidden") || !strings.Contains(env, "app_secret=" + RedactedValue) {
+		t.Errorf("redacted env: %q\n", records[0].Env)
+	}
+	if _, err = ReadAuditLog(strings.NewReader("{\"args\":[]}\nnot json\n")); err == nil ||
+			!strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ReadAuditLog(bad): %v\n", err)
+	}
+
+	t.Log("\tend: TestAuditLog")
+}
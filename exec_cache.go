@@ -0,0 +1,396 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Execution Cache
+
+// An ExecCache lets unchanged steps be skipped, make-style. A command
+// using a cache is identified by a key made from its arguments, working
+// directory, the environment variables it declares as significant and
+// the contents of the input files and directories it declares. If an
+// entry for the key exists, the command is not run. Instead its stdout
+// and stderr are replayed, its exit code is returned and the output
+// files it declared are restored. Otherwise, the command is run and,
+// if it succeeds, its output and output files are saved under the key.
+// Since changing any input changes the key, old entries are never used
+// again once an input changes. Clear removes them.
+
+// Commands that read stdin or are stages of a Pipeline can not be
+// keyed and are always run.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//============================================================================
+//                             Execution Cache
+//============================================================================
+
+// ExecCache is a directory of saved command results. It may be shared by
+// several commands and goroutines.
+type ExecCache struct {
+	dir			*Path
+	failures	bool			// Also save commands that exit non-zero
+}
+
+// execCacheEntry is saved as "entry.json" in the key's directory with
+// the output files saved beside it as "0", "1", ...
+type execCacheEntry struct {
+	Args		[]string		`json:"args"`
+	Dir			string			`json:"dir,omitempty"`
+	ExitCode	int				`json:"exit_code"`
+	Stdout		[]byte			`json:"stdout,omitempty"`
+	Stderr		[]byte			`json:"stderr,omitempty"`
+	Outputs		[]string		`json:"outputs,omitempty"`
+	Created		time.Time		`json:"created"`
+}
+
+// Clear removes all of the saved results.
+func (ec *ExecCache) Clear( ) error {
+	entries, err := ioutil.ReadDir(ec.dir.Absolute())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range entries {
+		if err = os.RemoveAll(ec.dir.Append(fi.Name()).Absolute()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dir returns the directory holding the saved results.
+func (ec *ExecCache) Dir( ) *Path {
+	return ec.dir
+}
+
+// SetCacheFailures sets whether commands that run to completion but
+// exit non-zero are saved so that their failure is replayed. Normally,
+// only successful commands are saved.
+func (ec *ExecCache) SetCacheFailures(f bool) *ExecCache {
+	ec.failures = f
+	return ec
+}
+
+// Key returns the key of the command's result or "" if the command can
+// not be cached. An error is returned if an input can not be read.
+func (ec *ExecCache) Key(c *ExecCmd) (string, error) {
+	if (c.stdin != nil) || (c.pipeIn != nil) || (c.pipeOut != nil) {
+		return "", nil
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "exec cache 1\n")
+	for _, a := range c.cmd.Args {
+		fmt.Fprintf(h, "arg %q\n", a)
+	}
+	dir := c.WorkDir()
+	if dir == "" {
+		dir = NewCurrentWorkDir().Absolute()
+	}
+	fmt.Fprintf(h, "dir %q\n", dir)
+
+	env := envMap(c.Environ())
+	keys := append([]string{}, c.cacheEnv...)
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v, ok := env[k]; ok {
+			fmt.Fprintf(h, "env %q=%q\n", k, v)
+		} else {
+			fmt.Fprintf(h, "env %q unset\n", k)
+		}
+	}
+
+	for _, p := range sortedPaths(c.cacheInputs) {
+		fmt.Fprintf(h, "input %q\n", p)
+		if err := hashTree(h, p); err != nil {
+			return "", err
+		}
+	}
+	for _, p := range sortedPaths(c.cacheOutputs) {
+		fmt.Fprintf(h, "output %q\n", p)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sortedPaths returns the absolute paths sorted.
+func sortedPaths(paths []*Path) []string {
+	s := make([]string, 0, len(paths))
+	for _, p := range paths {
+		s = append(s, p.Absolute())
+	}
+	sort.Strings(s)
+	return s
+}
+
+// hashTree writes the contents of a file or of every file within a
+// directory to the hash. A path that does not exist is hashed as such
+// so that creating it changes the key. Symbolic links are followed
+// so that it is what the command reads that is hashed. A link within
+// a directory is hashed as its target and, if the target is a file,
+// the target's contents. Links to directories within a directory are
+// not followed so that a loop of links can not be walked forever.
+func hashTree(h io.Writer, root string) error {
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		fmt.Fprintf(h, "missing\n")
+		return nil
+	}
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(resolved, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(resolved, path)
+		switch {
+		case fi.IsDir():
+			fmt.Fprintf(h, "dir %q\n", filepath.ToSlash(rel))
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "link %q %q\n", filepath.ToSlash(rel), target)
+			if tfi, err := os.Stat(path); (err == nil) && tfi.Mode().IsRegular() {
+				return hashFile(h, rel, path, tfi)
+			}
+		case fi.Mode().IsRegular():
+			return hashFile(h, rel, path, fi)
+		}
+		return nil
+	})
+}
+
+// hashFile writes the name, size and contents of a file to the hash.
+func hashFile(h io.Writer, rel, path string, fi os.FileInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(h, "file %q %d\n", filepath.ToSlash(rel), fi.Size())
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// load returns the saved result for the key or nil if there is none.
+func (ec *ExecCache) load(key string) *execCacheEntry {
+	var e		execCacheEntry
+
+	text, err := ioutil.ReadFile(ec.dir.Append(key).Append("entry.json").Absolute())
+	if err != nil {
+		return nil
+	}
+	if err = json.Unmarshal(text, &e); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// restore copies the saved output files back to where the command
+// wrote them.
+func (ec *ExecCache) restore(key string, e *execCacheEntry) error {
+	for i, p := range e.Outputs {
+		src := ec.dir.Append(key).Append(strconv.Itoa(i)).Absolute()
+		if _, err := os.Lstat(src); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		if err := copyTree(src, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save saves the command's result and output files under the key. The
+// entry is built in a temporary directory and renamed into place so
+// that a partial entry is never seen.
+func (ec *ExecCache) save(key string, c *ExecCmd, code int, stdout, stderr []byte) error {
+	if err := os.MkdirAll(ec.dir.Absolute(), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(ec.dir.Absolute(), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	e := execCacheEntry{}
+	e.Args = append([]string{}, c.cmd.Args...)
+	e.Dir = c.WorkDir()
+	e.ExitCode = code
+	e.Stdout = stdout
+	e.Stderr = stderr
+	e.Outputs = sortedPaths(c.cacheOutputs)
+	e.Created = time.Now()
+	for i, p := range e.Outputs {
+		if err = copyTree(p, filepath.Join(tmp, strconv.Itoa(i))); err != nil {
+			return err
+		}
+	}
+	text, err := json.MarshalIndent(&e, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(tmp, "entry.json"), text, 0644); err != nil {
+		return err
+	}
+
+	dst := ec.dir.Append(key).Absolute()
+	if err = os.Rename(tmp, dst); err != nil {
+		if _, statErr := os.Stat(dst); statErr == nil {
+			// Another run saved the same result first.
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// run runs the command through its runner unless its result is saved.
+// The returned flag is true if the result was replayed.
+func (ec *ExecCache) run(ctx context.Context, c *ExecCmd, stdout, stderr io.Writer) (int, bool, error) {
+	var outBuf	bytes.Buffer
+	var errBuf	bytes.Buffer
+
+	key, err := ec.Key(c)
+	if err != nil {
+		return -1, false, err
+	}
+	if key == "" {
+		code, err := c.Runner().RunCommand(ctx, c, stdout, stderr)
+		return code, false, err
+	}
+
+	if e := ec.load(key); e != nil {
+		if ec.restore(key, e) == nil {
+			stdout.Write(e.Stdout)
+			stderr.Write(e.Stderr)
+			if e.ExitCode != 0 {
+				return e.ExitCode, true, &ExitStatusError{Code: e.ExitCode}
+			}
+			return 0, true, nil
+		}
+	}
+
+	code, err := c.Runner().RunCommand(ctx, c, io.MultiWriter(stdout, &outBuf), io.MultiWriter(stderr, &errBuf))
+	if (err == nil) || (ec.failures && (code > 0) && (ctx.Err() == nil) && isExitStatus(err)) {
+		// The command has done its work so failing to save is ignored.
+		ec.save(key, c, code, outBuf.Bytes(), errBuf.Bytes())
+	}
+	return code, false, err
+}
+
+// isExitStatus returns true if the error only reports a non-zero exit.
+func isExitStatus(err error) bool {
+	var ee		*exec.ExitError
+	var se		*ExitStatusError
+
+	return errors.As(err, &ee) || errors.As(err, &se)
+}
+
+// copyTree copies a file, symbolic link or directory.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			in, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err = io.Copy(out, in); err != nil {
+				out.Close()
+				return err
+			}
+			return out.Close()
+		}
+		return nil
+	})
+}
+
+// NewExecCache returns a cache keeping its results in the given
+// directory which is created when needed.
+func NewExecCache(dir *Path) *ExecCache {
+	return &ExecCache{dir: dir}
+}
+
+//============================================================================
+//                             Command Caching
+//============================================================================
+
+// Cache returns the cache of the command or nil if it has none.
+func (c *ExecCmd) Cache( ) *ExecCache {
+	return c.cache
+}
+
+// SetCache sets the cache used to skip the command when its inputs
+// have not changed. nil always runs the command.
+func (c *ExecCmd) SetCache(ec *ExecCache) *ExecCmd {
+	c.cache = ec
+	return c
+}
+
+// CacheEnv declares environment variables that affect the command's
+// result. Other variables are not part of its key.
+func (c *ExecCmd) CacheEnv(keys ...string) *ExecCmd {
+	c.cacheEnv = append(c.cacheEnv, keys...)
+	return c
+}
+
+// CacheInputs declares files or directories that the command reads.
+func (c *ExecCmd) CacheInputs(paths ...*Path) *ExecCmd {
+	c.cacheInputs = append(c.cacheInputs, paths...)
+	return c
+}
+
+// CacheOutputs declares files or directories that the command creates
+// which are restored when its result is replayed. A command that does
+// not create all of them is not saved.
+func (c *ExecCmd) CacheOutputs(paths ...*Path) *ExecCmd {
+	c.cacheOutputs = append(c.cacheOutputs, paths...)
+	return c
+}
@@ -0,0 +1,151 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExecCache(t *testing.T) {
+	var lines	[]string
+
+	t.Log("TestExecCache()")
+
+	dir := NewTempDir().Append("exec_cache_test")
+	dir.RemoveDir()
+	if err := dir.CreateDir(); err != nil {
+		t.Fatalf("CreateDir() failed: %s\n", err.Error())
+	}
+	defer dir.RemoveDir()
+	cache := NewExecCache(dir.Append("cache"))
+	input := dir.Append("in.txt")
+	output := dir.Append("out.txt")
+	runs := dir.Append("runs.txt")
+	ioutil.WriteFile(input.Absolute(), []byte("hello\n"), 0644)
+
+	// The command counts its runs, copies its input to its output and
+	// writes to stdout and stderr.
+	newCmd := func() *ExecCmd {
+		script := `echo run >> runs.txt; tr a-z A-Z < in.txt > out.txt; echo "out${MODE:+ $MODE}"; echo err >&2`
+		return NewExecArgs("sh", "-c", script).Dir(dir).SetCache(cache).
+				CacheEnv("MODE").CacheInputs(input).CacheOutputs(output)
+	}
+	runCount := func() int {
+		text, _ := ioutil.ReadFile(runs.Absolute())
+		return strings.Count(string(text), "run")
+	}
+
+	r, err := newCmd().RunResult()
+	if err != nil || r.Cached || runCount() != 1 {
+		t.Fatalf("first run: %v %v %d\n", err, r.Cached, runCount())
+	}
+
+	// Unchanged inputs replay the result and restore the output.
+	output.DeleteFile()
+	r, err = newCmd().OnStdoutLine(func(s string) { lines = append(lines, s) }).RunResult()
+	if err != nil || !r.Cached || runCount() != 1 {
+		t.Fatalf("second run: %v %v %d\n", err, r.Cached, runCount())
+	}
+	if r.StdoutString() != "out" || r.StderrString() != "err" || r.ExitCode != 0 {
+		t.Errorf("replayed result: %q %q %d\n", r.Stdout, r.Stderr, r.ExitCode)
+	}
+	if len(lines) != 1 || lines[0] != "out" {
+		t.Errorf("replayed lines: %q\n", lines)
+	}
+	if text, _ := ioutil.ReadFile(output.Absolute()); string(text) != "HELLO\n" {
+		t.Errorf("restored output: %q\n", text)
+	}
+
+	// Changing an input, a declared variable or the arguments runs it.
+	ioutil.WriteFile(input.Absolute(), []byte("changed\n"), 0644)
+	if r, _ = newCmd().RunResult(); r.Cached || runCount() != 2 {
+		t.Errorf("changed input was replayed\n")
+	}
+	if text, _ := ioutil.ReadFile(output.Absolute()); string(text) != "CHANGED\n" {
+		t.Errorf("changed output: %q\n", text)
+	}
+	if r, _ = newCmd().SetEnv("MODE", "x").RunResult(); r.Cached || r.StdoutString() != "out x" {
+		t.Errorf("changed environment was replayed\n")
+	}
+	if r, _ = newCmd().SetEnv("OTHER", "x").RunResult(); !r.Cached {
+		t.Errorf("undeclared variable changed the key\n")
+	}
+	if runCount() != 3 {
+		t.Errorf("runs: %d\n", runCount())
+	}
+
+	// Failures are not saved unless asked for.
+	fail := func() *ExecCmd {
+		return NewExecArgs("sh", "-c", "echo run >> runs.txt; exit 4").Dir(dir).SetCache(cache)
+	}
+	fail().Run()
+	if r, err = fail().RunResult(); err == nil || r.Cached || runCount() != 5 {
+		t.Errorf("failure was replayed: %v %d\n", r.Cached, runCount())
+	}
+	cache.SetCacheFailures(true)
+	fail().Run()
+	if r, err = fail().RunResult(); err == nil || !r.Cached || r.ExitCode != 4 || runCount() != 6 {
+		t.Errorf("failure was not replayed: %v %v %d %d\n", err, r.Cached, r.ExitCode, runCount())
+	}
+
+	if err = cache.Clear(); err != nil {
+		t.Errorf("Clear() failed: %s\n", err.Error())
+	}
+	if r, _ = newCmd().RunResult(); r.Cached {
+		t.Errorf("cleared result was replayed\n")
+	}
+
+	t.Log("\tend: TestExecCache")
+}
+
+func TestExecCacheSymlinks(t *testing.T) {
+
+	t.Log("TestExecCacheSymlinks()")
+
+	dir := NewTempDir().Append("exec_cache_links_test")
+	dir.RemoveDir()
+	if err := dir.Append("tree").CreateDir(); err != nil {
+		t.Fatalf("CreateDir() failed: %s\n", err.Error())
+	}
+	defer dir.RemoveDir()
+	cache := NewExecCache(dir.Append("cache"))
+	target := dir.Append("target.txt")
+	ioutil.WriteFile(target.Absolute(), []byte("a"), 0644)
+	link := dir.Append("link.txt")
+	if err := os.Symlink(target.Absolute(), link.Absolute()); err != nil {
+		t.Skipf("Symlink() failed: %s\n", err.Error())
+	}
+	tree := dir.Append("tree")
+	os.Symlink(target.Absolute(), tree.Append("link.txt").Absolute())
+
+	// Changing the file that a declared input links to, or that a link
+	// within a declared directory links to, runs the command again.
+	for _, input := range []*Path{link, tree} {
+		ioutil.WriteFile(target.Absolute(), []byte("a"), 0644)
+		wd := dir
+		if input == tree {
+			wd = tree
+		}
+		cat := func() *ExecCmd {
+			return NewExecArgs("cat", "link.txt").Dir(wd).SetCache(cache).CacheInputs(input)
+		}
+		if r, err := cat().RunResult(); err != nil || r.StdoutString() != "a" {
+			t.Fatalf("cat %s: %q %v\n", input.Base(), r.Stdout, err)
+		}
+		if r, _ := cat().RunResult(); !r.Cached {
+			t.Errorf("cat %s was not replayed\n", input.Base())
+		}
+		ioutil.WriteFile(target.Absolute(), []byte("b"), 0644)
+		if r, _ := cat().RunResult(); r.Cached || r.StdoutString() != "b" {
+			t.Errorf("cat %s replayed %q after its target changed\n", input.Base(), r.Stdout)
+		}
+	}
+
+	t.Log("\tend: TestExecCacheSymlinks")
+}
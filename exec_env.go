@@ -0,0 +1,173 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Environment and Working Directory
+
+// These methods build the environment and working directory of an
+// ExecCmd without reaching into the underlying exec.Cmd. Until one of
+// them is called, the command inherits this process's environment.
+// The first change takes a copy of that environment (or of the
+// environment already set in the exec.Cmd) which is then modified.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//============================================================================
+//                             Environment
+//============================================================================
+
+// EnvRedactPattern matches the names of environment variables whose
+// values should not be logged.
+var EnvRedactPattern = regexp.MustCompile(`(?i)(PASSW(OR)?D|SECRET|TOKEN|CREDENTIAL|PRIVATE|API_?KEY|AUTH)`)
+
+// RedactedValue replaces the values of redacted environment variables.
+const RedactedValue = "[REDACTED]"
+
+// RedactEnv returns a copy of the given "key=value" environment with
+// the values of keys matching the pattern replaced by RedactedValue.
+// If re is nil, EnvRedactPattern is used.
+func RedactEnv(env []string, re *regexp.Regexp) []string {
+	if re == nil {
+		re = EnvRedactPattern
+	}
+	out := make([]string, len(env))
+	for i, kv := range env {
+		out[i] = kv
+		if k := strings.SplitN(kv, "=", 2)[0]; re.MatchString(k) {
+			out[i] = k + "=" + RedactedValue
+		}
+	}
+	return out
+}
+
+// envMap converts a "key=value" environment to a map. Later entries
+// replace earlier ones.
+func envMap(env []string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range env {
+		a := strings.SplitN(kv, "=", 2)
+		if len(a) == 2 {
+			m[a[0]] = a[1]
+		} else {
+			m[a[0]] = ""
+		}
+	}
+	return m
+}
+
+// editEnv returns the environment being built, taking a copy of the
+// current one if this is the first change.
+func (c *ExecCmd) editEnv( ) map[string]string {
+	if c.env == nil {
+		if c.cmd.Env != nil {
+			c.env = envMap(c.cmd.Env)
+		} else {
+			c.env = envMap(os.Environ())
+		}
+	}
+	return c.env
+}
+
+// updateEnv stores the environment being built into the exec.Cmd.
+func (c *ExecCmd) updateEnv( ) *ExecCmd {
+	c.cmd.Env = c.Environ()
+	return c
+}
+
+// ClearEnv removes all variables from the environment.
+func (c *ExecCmd) ClearEnv( ) *ExecCmd {
+	c.env = map[string]string{}
+	return c.updateEnv()
+}
+
+// EnvFromSharedData sets a variable for every definition in the shared
+// data. The variable's name is the prefix followed by the definition's
+// name in upper case such as "GEN_OUTDIR" for "outDir" with a prefix
+// of "GEN_". Booleans become "true" or "false".
+func (c *ExecCmd) EnvFromSharedData(sd *SharedData, prefix string) *ExecCmd {
+	env := c.editEnv()
+	for k, v := range sd.Defns() {
+		env[prefix + strings.ToUpper(k)] = fmt.Sprintf("%v", v)
+	}
+	return c.updateEnv()
+}
+
+// Environ returns the final environment of the command as sorted
+// "key=value" strings.
+func (c *ExecCmd) Environ( ) []string {
+	// Empty but not nil since a nil exec.Cmd Env inherits ours.
+	env := []string{}
+
+	if c.env == nil {
+		if c.cmd.Env != nil {
+			env = append(env, c.cmd.Env...)
+		} else {
+			env = os.Environ()
+		}
+		sort.Strings(env)
+		return env
+	}
+	for k, v := range c.env {
+		env = append(env, k + "=" + v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// InheritEnv copies the given variables from this process's environment
+// if they are set. It is normally used after ClearEnv.
+func (c *ExecCmd) InheritEnv(keys ...string) *ExecCmd {
+	env := c.editEnv()
+	for _, k := range keys {
+		if v, ok := os.LookupEnv(k); ok {
+			env[k] = v
+		}
+	}
+	return c.updateEnv()
+}
+
+// RedactedEnviron returns Environ() with the values of secrets
+// replaced so that it can be logged.
+func (c *ExecCmd) RedactedEnviron( ) []string {
+	return RedactEnv(c.Environ(), nil)
+}
+
+// SetEnv sets a variable in the environment.
+func (c *ExecCmd) SetEnv(k, v string) *ExecCmd {
+	c.editEnv()[k] = v
+	return c.updateEnv()
+}
+
+// UnsetEnv removes a variable from the environment.
+func (c *ExecCmd) UnsetEnv(k string) *ExecCmd {
+	delete(c.editEnv(), k)
+	return c.updateEnv()
+}
+
+//============================================================================
+//                             Working Directory
+//============================================================================
+
+// Dir sets the working directory of the command. If p is nil, the
+// command runs in this process's current directory.
+func (c *ExecCmd) Dir(p *Path) *ExecCmd {
+	if p == nil {
+		c.cmd.Dir = ""
+	} else {
+		c.cmd.Dir = p.Absolute()
+	}
+	return c
+}
+
+// WorkDir returns the working directory of the command or "" if it
+// runs in this process's current directory.
+func (c *ExecCmd) WorkDir( ) string {
+	return c.cmd.Dir
+}
@@ -0,0 +1,111 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExecEnv(t *testing.T) {
+	var err 	error
+
+	t.Log("TestExecEnv()")
+
+	os.Setenv("EXEC_ENV_TEST_A", "a")
+	os.Setenv("EXEC_ENV_TEST_B", "b")
+	defer os.Unsetenv("EXEC_ENV_TEST_A")
+	defer os.Unsetenv("EXEC_ENV_TEST_B")
+
+	// By default the environment is inherited.
+	cmd := NewExecArgs("sh", "-c", "echo $EXEC_ENV_TEST_A$EXEC_ENV_TEST_B")
+	out, err := cmd.RunWithOutput()
+	if err != nil || out != "ab" {
+		t.Errorf("inherited environment: %q %v\n", out, err)
+	}
+
+	cmd = NewExecArgs("env").ClearEnv().InheritEnv("EXEC_ENV_TEST_A", "EXEC_ENV_TEST_X")
+	cmd.SetEnv("Z", "last").SetEnv("M", "x y").SetEnv("DEL", "1").UnsetEnv("DEL")
+	expected := []string{"EXEC_ENV_TEST_A=a", "M=x y", "Z=last"}
+	env := cmd.Environ()
+	if !equalArgs(env, expected) {
+		t.Errorf("Environ() = %q, but should be %q\n", env, expected)
+	}
+	r, err := cmd.RunResult()
+	if err != nil {
+		t.Fatalf("env failed: %s\n", err.Error())
+	}
+	lines := strings.Split(r.StdoutString(), "\n")
+	if !equalArgs(lines, expected) {
+		t.Errorf("env = %q, but should be %q\n", lines, expected)
+	}
+
+	// Clearing the environment leaves the command with none at all.
+	out, err = NewExecArgs("env").ClearEnv().RunWithOutput()
+	if err != nil || out != "" {
+		t.Errorf("cleared environment: %q %v\n", out, err)
+	}
+	out, err = NewExecArgs("env").ClearEnv().SetEnv("ONLY", "1").UnsetEnv("ONLY").RunWithOutput()
+	if err != nil || out != "" {
+		t.Errorf("environment with everything unset: %q %v\n", out, err)
+	}
+
+	// Changes are made to the inherited environment.
+	cmd = NewExecArgs("sh", "-c", "echo $EXEC_ENV_TEST_A$EXEC_ENV_TEST_B")
+	out, err = cmd.UnsetEnv("EXEC_ENV_TEST_A").SetEnv("EXEC_ENV_TEST_B", "c").RunWithOutput()
+	if err != nil || out != "c" {
+		t.Errorf("modified environment: %q %v\n", out, err)
+	}
+
+	t.Log("\tend: TestExecEnv")
+}
+
+func TestExecEnvShared(t *testing.T) {
+
+	t.Log("TestExecEnvShared()")
+
+	sd := &SharedData{}
+	sd.Init()
+	sd.SetOutDir("/tmp/out dir")
+	sd.SetNoop(true)
+	sd.SetDefn("dbPassword", "hunter2")
+	cmd := NewExecArgs("env").ClearEnv().EnvFromSharedData(sd, "GEN_")
+	env := envMap(cmd.Environ())
+	if env["GEN_OUTDIR"] != "/tmp/out dir" || env["GEN_NOOP"] != "true" || env["GEN_FORCE"] != "false" {
+		t.Errorf("EnvFromSharedData: %q\n", cmd.Environ())
+	}
+
+	redacted := envMap(cmd.RedactedEnviron())
+	if redacted["GEN_DBPASSWORD"] != RedactedValue || redacted["GEN_OUTDIR"] != "/tmp/out dir" {
+		t.Errorf("RedactedEnviron(): %q\n", cmd.RedactedEnviron())
+	}
+	if env["GEN_DBPASSWORD"] != "hunter2" {
+		t.Errorf("RedactedEnviron() changed the environment: %q\n", cmd.Environ())
+	}
+
+	t.Log("\tend: TestExecEnvShared")
+}
+
+func TestExecDir(t *testing.T) {
+
+	t.Log("TestExecDir()")
+
+	dir := NewPath("./test")
+	cmd := NewExecArgs("pwd").Dir(dir)
+	if cmd.WorkDir() != dir.Absolute() {
+		t.Errorf("WorkDir(): %s\n", cmd.WorkDir())
+	}
+	out, err := cmd.RunWithOutput()
+	if err != nil || out != dir.Absolute() {
+		t.Errorf("pwd: %q %v, but should be %s\n", out, err, dir.Absolute())
+	}
+	if NewExecArgs("pwd").Dir(dir).Dir(nil).WorkDir() != "" {
+		t.Errorf("Dir(nil) did not reset the directory\n")
+	}
+
+	t.Log("\tend: TestExecDir")
+}
@@ -0,0 +1,85 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Execution Errors
+
+// An ExecError wraps the failure of an ExecCmd with enough context to
+// be useful in a log line: the command, where it ran, how it ended and
+// the end of what it wrote to stderr. The underlying error remains
+// available through errors.Is and errors.As.
+
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+//============================================================================
+//                             Execution Error
+//============================================================================
+
+// ExecErrorTailLines is the number of lines at the end of stderr kept
+// in an ExecError.
+var ExecErrorTailLines = 10
+
+// ExecError describes a command that failed.
+type ExecError struct {
+	Command		string			// Quoted command line
+	Dir			string			// Working directory, "" == current
+	ExitCode	int				// -1 if not started or killed
+	Signal		string			// Signal that ended the command if any
+	StderrTail	[]string		// Last lines written to stderr
+	Err			error			// Underlying error
+}
+
+// Error returns a one line description of the failure.
+func (e *ExecError) Error( ) string {
+	var b		strings.Builder
+
+	fmt.Fprintf(&b, "Error: exec: %s", e.Command)
+	if e.Dir != "" {
+		fmt.Fprintf(&b, " (dir: %s)", e.Dir)
+	}
+	fmt.Fprintf(&b, ": %s", ErrorString(e.Err))
+	if (e.Signal != "") && !strings.Contains(ErrorString(e.Err), e.Signal) {
+		fmt.Fprintf(&b, " (signal: %s)", e.Signal)
+	}
+	if len(e.StderrTail) > 0 {
+		fmt.Fprintf(&b, "; stderr: %q", strings.Join(e.StderrTail, "\n"))
+	}
+	return b.String()
+}
+
+func (e *ExecError) Unwrap( ) error {
+	return e.Err
+}
+
+// stderrTail returns the last n lines of the given output ignoring
+// a trailing line ending.
+func stderrTail(stderr []byte, n int) []string {
+	s := strings.TrimRight(string(stderr), "\r\n")
+	if (s == "") || (n <= 0) {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	return lines
+}
+
+// newExecError wraps the error of the given result of the command.
+func (c *ExecCmd) newExecError(r *ExecResult, err error) *ExecError {
+	e := &ExecError{}
+	e.Command = c.CommandString()
+	e.Dir = c.cmd.Dir
+	e.ExitCode = r.ExitCode
+	e.Signal = r.Signal
+	e.StderrTail = stderrTail(r.Stderr, ExecErrorTailLines)
+	e.Err = err
+	return e
+}
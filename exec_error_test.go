@@ -0,0 +1,71 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecError(t *testing.T) {
+	var ee		*ExecError
+	var xe		*exec.ExitError
+
+	t.Log("TestExecError()")
+
+	dir := NewPath("./test")
+	cmd := NewExecArgs("sh", "-c", "for i in $(seq 1 15); do echo line$i >&2; done; exit 3").Dir(dir)
+	err := cmd.Run()
+	if err == nil {
+		t.Fatalf("Run() should have failed!\n")
+	}
+	t.Logf("\t%s\n", err.Error())
+	if !errors.As(err, &ee) {
+		t.Fatalf("Run() error is not an ExecError: %#v\n", err)
+	}
+	if !errors.As(err, &xe) || xe.ExitCode() != 3 {
+		t.Errorf("Run() error does not wrap the exec.ExitError: %#v\n", ee.Err)
+	}
+	if ee.ExitCode != 3 || ee.Dir != dir.Absolute() || ee.Signal != "" {
+		t.Errorf("ExecError: %d %q %q\n", ee.ExitCode, ee.Dir, ee.Signal)
+	}
+	if ee.Command != cmd.CommandString() {
+		t.Errorf("ExecError command: %s\n", ee.Command)
+	}
+	if len(ee.StderrTail) != ExecErrorTailLines || ee.StderrTail[0] != "line6" || ee.StderrTail[9] != "line15" {
+		t.Errorf("ExecError stderr tail: %q\n", ee.StderrTail)
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "\n") {
+		t.Errorf("Error() is not one line: %s\n", msg)
+	}
+	if !strings.Contains(msg, "sh -c") || !strings.Contains(msg, "exit status 3") ||
+			!strings.Contains(msg, "line15") || !strings.Contains(msg, dir.Absolute()) {
+		t.Errorf("Error() is missing information: %s\n", msg)
+	}
+
+	// Killed commands report the signal.
+	err = NewExecArgs("sleep", "10").SetTimeout(50 * time.Millisecond).Run()
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("timeout error: %#v\n", err)
+	}
+	if !errors.As(err, &ee) || ee.Signal != "terminated" || ee.ExitCode != -1 {
+		t.Errorf("timeout ExecError: %#v\n", ee)
+	}
+	t.Logf("\t%s\n", err.Error())
+
+	// As do commands that could not be started.
+	err = NewExecArgs("./xyzzy_not_there").Run()
+	if !errors.As(err, &ee) || ee.ExitCode != -1 {
+		t.Errorf("not found ExecError: %#v\n", err)
+	}
+	t.Logf("\t%s\n", err.Error())
+
+	t.Log("\tend: TestExecError")
+}
@@ -0,0 +1,65 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Input
+
+// These methods supply the stdin of an ExecCmd. Input is streamed to
+// the command while its output is being collected so that neither
+// large inputs nor large outputs can deadlock. Strings and files are
+// re-read each time that the command is run (such as when it is
+// retried), but a reader can only be consumed once.
+
+package util
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+//============================================================================
+//                             Command Input
+//============================================================================
+
+// WithStdinPath makes the contents of the given file the command's stdin.
+// The file is opened each time that the command is run.
+func (c *ExecCmd) WithStdinPath(p *Path) *ExecCmd {
+	c.stdin = func() (io.Reader, func(), error) {
+		f, err := os.Open(p.Absolute())
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() { f.Close() }, nil
+	}
+	return c
+}
+
+// WithStdinReader makes everything read from r the command's stdin.
+func (c *ExecCmd) WithStdinReader(r io.Reader) *ExecCmd {
+	c.stdin = func() (io.Reader, func(), error) {
+		return r, func() {}, nil
+	}
+	return c
+}
+
+// WithStdinString makes the given string the command's stdin.
+func (c *ExecCmd) WithStdinString(s string) *ExecCmd {
+	c.stdin = func() (io.Reader, func(), error) {
+		return strings.NewReader(s), func() {}, nil
+	}
+	return c
+}
+
+// openStdin returns the reader to be used as the stdin of the next
+// run of the command and a function to close it afterwards. If the
+// reader is a file, it is closed as soon as the command has started.
+// A nil reader means that the exec.Cmd's Stdin is used as is.
+func (c *ExecCmd) openStdin( ) (io.Reader, func(), error) {
+	if c.pipeIn != nil {
+		return c.pipeIn, func() {}, nil
+	}
+	if c.stdin == nil {
+		return nil, func() {}, nil
+	}
+	return c.stdin()
+}
@@ -0,0 +1,117 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPayload returns n bytes of text made up of numbered lines.
+func testPayload(n int) string {
+	var b		strings.Builder
+
+	for i := 0; b.Len() < n; i++ {
+		b.WriteString("line " + strconv.Itoa(i) + " of the test payload\n")
+	}
+	return b.String()[:n]
+}
+
+func TestExecStdinString(t *testing.T) {
+
+	t.Log("TestExecStdinString()")
+
+	// A large input and output at the same time must not deadlock.
+	payload := testPayload(8 << 20)
+	cmd := NewExecArgs("cat").WithStdinString(payload).SetTimeout(30 * time.Second)
+	r, err := cmd.RunResult()
+	if err != nil {
+		t.Fatalf("cat failed: %s\n", err.Error())
+	}
+	if len(r.Stdout) != len(payload) || string(r.Stdout) != payload {
+		t.Errorf("cat returned %d bytes, but should be %d\n", len(r.Stdout), len(payload))
+	}
+
+	// The input is supplied again on each run.
+	cmd = NewExecArgs("wc", "-l").WithStdinString("a\nb\nc\n")
+	for i := 0; i < 2; i++ {
+		out, err := cmd.RunWithOutput()
+		if err != nil || out != "3" {
+			t.Errorf("wc -l run %d: %q %v\n", i+1, out, err)
+		}
+	}
+
+	t.Log("\tend: TestExecStdinString")
+}
+
+func TestExecStdinPath(t *testing.T) {
+
+	t.Log("TestExecStdinPath()")
+
+	file := NewTempDir().Append("exec_stdin_test.txt")
+	defer file.DeleteFile()
+	payload := testPayload(4 << 20)
+	if err := ioutil.WriteFile(file.Absolute(), []byte(payload), 0644); err != nil {
+		t.Fatalf("WriteFile(%s) failed: %s\n", file.String(), err.Error())
+	}
+
+	out, err := NewExecArgs("wc", "-c").WithStdinPath(file).RunWithOutput()
+	if err != nil || out != strconv.Itoa(len(payload)) {
+		t.Errorf("wc -c: %q %v\n", out, err)
+	}
+	r, err := NewExecArgs("sh", "-c", "cat; cat >&2").WithStdinPath(file).RunResult()
+	if err != nil || string(r.Stdout) != payload || len(r.Stderr) != 0 {
+		t.Errorf("cat: %d %d %v\n", len(r.Stdout), len(r.Stderr), err)
+	}
+
+	err = NewExecArgs("cat").WithStdinPath(NewPath("./xyzzy_not_there")).Run()
+	if err == nil {
+		t.Errorf("WithStdinPath(xyzzy) should have failed!\n")
+	}
+
+	t.Log("\tend: TestExecStdinPath")
+}
+
+func TestExecStdinReader(t *testing.T) {
+
+	t.Log("TestExecStdinReader()")
+
+	payload := testPayload(3 << 20)
+	pr, pw := io.Pipe()
+	go func() {
+		// Write in small pieces while the command is running.
+		for i := 0; i < len(payload); i += 4096 {
+			end := i + 4096
+			if end > len(payload) {
+				end = len(payload)
+			}
+			pw.Write([]byte(payload[i:end]))
+		}
+		pw.Close()
+	}()
+	var out bytes.Buffer
+	cmd := NewExecArgs("cat").WithStdinReader(pr).TeeStdout(&out)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("cat failed: %s\n", err.Error())
+	}
+	if out.String() != payload {
+		t.Errorf("cat returned %d bytes, but should be %d\n", out.Len(), len(payload))
+	}
+
+	// Pipelines take their first stage's input as well.
+	p := NewPipeline(NewExecArgs("sort").WithStdinString("b\na\n"), NewExecArgs("head", "-n", "1"))
+	r, err := p.RunResult()
+	if err != nil || string(r.Stdout()) != "a\n" {
+		t.Errorf("sort | head: %q %v\n", r.Stdout(), err)
+	}
+
+	t.Log("\tend: TestExecStdinReader")
+}
@@ -0,0 +1,201 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Execution Options
+
+// ExecOptions controls how ExecCmds are executed. It is normally
+// created once per program, often from the SharedData, and attached
+// to every ExecCmd that the program runs so that the Debug, Noop and
+// Quiet flags are honored everywhere. In Noop mode, commands are not
+// run but are recorded so that a "would run" plan can be printed.
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+//============================================================================
+//                             Execution Options
+//============================================================================
+
+// ExecOptions is safe for use by several goroutines at once.
+type ExecOptions struct {
+	mu			sync.Mutex
+	audit		*AuditLog			// nil == none
+	debug		bool
+	noop		bool
+	quiet		bool
+	log			io.Writer			// nil == use the log package
+	recorded	[]string			// Commands not run because of Noop
+	runner		CommandRunner		// nil == DefaultRunner
+	shared		*SharedData
+}
+
+// Debug returns true if commands should be echoed before they are run.
+func (o *ExecOptions) Debug( ) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.shared != nil && o.shared.Debug() {
+		return true
+	}
+	return o.debug
+}
+
+func (o *ExecOptions) SetDebug(f bool) *ExecOptions {
+	o.mu.Lock()
+	o.debug = f
+	o.mu.Unlock()
+	return o
+}
+
+// SetLog sets where echoed and skipped commands are written. If nil,
+// they are written using the log package.
+func (o *ExecOptions) SetLog(w io.Writer) *ExecOptions {
+	o.mu.Lock()
+	o.log = w
+	o.mu.Unlock()
+	return o
+}
+
+// Noop returns true if commands should be recorded instead of run.
+func (o *ExecOptions) Noop( ) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.shared != nil && o.shared.Noop() {
+		return true
+	}
+	return o.noop
+}
+
+func (o *ExecOptions) SetNoop(f bool) *ExecOptions {
+	o.mu.Lock()
+	o.noop = f
+	o.mu.Unlock()
+	return o
+}
+
+// Quiet returns true if skipped commands should not be logged.
+func (o *ExecOptions) Quiet( ) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.shared != nil && o.shared.Quiet() {
+		return true
+	}
+	return o.quiet
+}
+
+func (o *ExecOptions) SetQuiet(f bool) *ExecOptions {
+	o.mu.Lock()
+	o.quiet = f
+	o.mu.Unlock()
+	return o
+}
+
+// Recorded returns the command strings of the commands that were
+// skipped because of Noop in the order that they were requested.
+func (o *ExecOptions) Recorded( ) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string{}, o.recorded...)
+}
+
+// ResetRecorded clears the list of recorded commands.
+func (o *ExecOptions) ResetRecorded( ) {
+	o.mu.Lock()
+	o.recorded = nil
+	o.mu.Unlock()
+}
+
+// Runner returns the runner used by commands with these options
+// or nil if DefaultRunner is to be used.
+func (o *ExecOptions) Runner( ) CommandRunner {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.runner
+}
+
+// SetRunner sets the runner used by commands with these options.
+func (o *ExecOptions) SetRunner(r CommandRunner) *ExecOptions {
+	o.mu.Lock()
+	o.runner = r
+	o.mu.Unlock()
+	return o
+}
+
+// Shared returns the SharedData that the flags follow if any.
+func (o *ExecOptions) Shared( ) *SharedData {
+	return o.shared
+}
+
+func (o *ExecOptions) logf(format string, a ...interface{}) {
+	o.mu.Lock()
+	w := o.log
+	o.mu.Unlock()
+	if w == nil {
+		log.Printf(format, a...)
+	} else {
+		fmt.Fprintf(w, format, a...)
+	}
+}
+
+func (o *ExecOptions) record(cmd string) {
+	o.mu.Lock()
+	o.recorded = append(o.recorded, cmd)
+	o.mu.Unlock()
+}
+
+// before is called just before a command would be executed. It echoes
+// and records the command as needed and returns true if the command
+// should actually be run.
+func (o *ExecOptions) before(c *ExecCmd) bool {
+	if o.Noop() {
+		o.record(c.CommandString())
+		if !o.Quiet() || o.Debug() {
+			o.logf("noop: %s\n", c.CommandString())
+		}
+		return false
+	}
+	if o.Debug() {
+		o.logf("exec: %s\n", c.CommandString())
+	}
+	return true
+}
+
+//----------------------------------------------------------------------------
+//							Command Methods
+//----------------------------------------------------------------------------
+
+// Options returns the execution options attached to the command
+// or nil if there are none.
+func (c *ExecCmd) Options( ) *ExecOptions {
+	return c.opts
+}
+
+// SetOptions attaches execution options to the command. The same
+// options are normally shared by all of a program's commands.
+func (c *ExecCmd) SetOptions(o *ExecOptions) *ExecCmd {
+	c.opts = o
+	return c
+}
+
+//----------------------------------------------------------------------------
+//							Class Functions
+//----------------------------------------------------------------------------
+
+func NewExecOptions() *ExecOptions {
+	o := ExecOptions{}
+	return &o
+}
+
+// NewExecOptionsShared creates execution options whose Debug, Noop and
+// Quiet flags follow those of the given SharedData as it changes. The
+// options' own flags may be set as well and either one enables a mode.
+func NewExecOptionsShared(sd *SharedData) *ExecOptions {
+	o := ExecOptions{}
+	o.shared = sd
+	return &o
+}
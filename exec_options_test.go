@@ -0,0 +1,100 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExecOptionsNoop(t *testing.T) {
+	var err 	error
+	var buf		bytes.Buffer
+
+	t.Log("TestExecOptionsNoop()")
+
+	sd := &SharedData{}
+	sd.Init()
+	sd.SetNoop(true)
+	opts := NewExecOptionsShared(sd).SetLog(&buf)
+
+	file := NewTempDir().Append("exec_noop_test.txt")
+	file.DeleteFile()
+	cmd := NewExecArgs("touch", file.String()).SetOptions(opts)
+	r, err := cmd.RunResult()
+	if err != nil {
+		t.Errorf("RunResult(touch) in noop failed: %s\n", err.Error())
+	}
+	if !r.DryRun || r.ExitCode != 0 {
+		t.Errorf("RunResult(touch) in noop result: %v %d\n", r.DryRun, r.ExitCode)
+	}
+	if file.IsPathRegularFile() {
+		file.DeleteFile()
+		t.Errorf("RunResult(touch) in noop created %s\n", file.String())
+	}
+	if err = NewExecArgs("echo", "a b").SetOptions(opts).Run(); err != nil {
+		t.Errorf("Run(echo) in noop failed: %s\n", err.Error())
+	}
+
+	recorded := opts.Recorded()
+	t.Logf("\trecorded: %q\n", recorded)
+	if len(recorded) != 2 || recorded[0] != cmd.CommandString() || recorded[1] != "echo 'a b'" {
+		t.Errorf("Recorded(): %q\n", recorded)
+	}
+	if !strings.Contains(buf.String(), "noop: echo 'a b'\n") {
+		t.Errorf("noop log: %q\n", buf.String())
+	}
+
+	buf.Reset()
+	sd.SetQuiet(true)
+	NewExecArgs("true").SetOptions(opts).Run()
+	if buf.Len() != 0 {
+		t.Errorf("quiet noop log: %q\n", buf.String())
+	}
+	if len(opts.Recorded()) != 3 {
+		t.Errorf("Recorded(): %q\n", opts.Recorded())
+	}
+	opts.ResetRecorded()
+	if len(opts.Recorded()) != 0 {
+		t.Errorf("ResetRecorded() left: %q\n", opts.Recorded())
+	}
+
+	t.Log("\tend: TestExecOptionsNoop")
+}
+
+func TestExecOptionsDebug(t *testing.T) {
+	var err 	error
+	var buf		bytes.Buffer
+
+	t.Log("TestExecOptionsDebug()")
+
+	sd := &SharedData{}
+	sd.Init()
+	opts := NewExecOptionsShared(sd).SetLog(&buf)
+
+	out, err := NewExecArgs("echo", "hi").SetOptions(opts).RunWithOutput()
+	if err != nil || out != "hi" {
+		t.Errorf("RunWithOutput(echo) failed: %q %v\n", out, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("log without debug: %q\n", buf.String())
+	}
+
+	sd.SetDebug(true)
+	out, err = NewExecArgs("echo", "hi there").SetOptions(opts).RunWithOutput()
+	if err != nil || out != "hi there" {
+		t.Errorf("RunWithOutput(echo) failed: %q %v\n", out, err)
+	}
+	if buf.String() != "exec: echo 'hi there'\n" {
+		t.Errorf("debug log: %q\n", buf.String())
+	}
+	if len(opts.Recorded()) != 0 {
+		t.Errorf("Recorded() without noop: %q\n", opts.Recorded())
+	}
+
+	t.Log("\tend: TestExecOptionsDebug")
+}
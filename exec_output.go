@@ -0,0 +1,182 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Output Streaming
+
+// These methods allow the output of an ExecCmd to be seen as it is
+// produced instead of only after the command completes. Each stream
+// can have line handlers called as lines arrive and writers that
+// receive a copy of everything written.
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+//============================================================================
+//                             Output Streaming
+//============================================================================
+
+// OnStderrLine adds a handler which is called with each line written to
+// stderr as it arrives. The line does not include its line ending.
+func (c *ExecCmd) OnStderrLine(f func(string)) *ExecCmd {
+	c.stderrLines = append(c.stderrLines, f)
+	return c
+}
+
+// OnStdoutLine adds a handler which is called with each line written to
+// stdout as it arrives. The line does not include its line ending.
+func (c *ExecCmd) OnStdoutLine(f func(string)) *ExecCmd {
+	c.stdoutLines = append(c.stdoutLines, f)
+	return c
+}
+
+// TeeStderr adds a writer that receives a copy of stderr as it arrives.
+// Stdout and stderr are copied at the same time, so a writer given to
+// both TeeStdout and TeeStderr is serialised for you, but a writer
+// shared in any other way (such as with another command) must be safe
+// for concurrent use.
+func (c *ExecCmd) TeeStderr(w io.Writer) *ExecCmd {
+	c.stderrTees = append(c.stderrTees, w)
+	return c
+}
+
+// TeeStdout adds a writer that receives a copy of stdout as it arrives.
+// The writer may also be given to TeeStderr (see TeeStderr).
+func (c *ExecCmd) TeeStdout(w io.Writer) *ExecCmd {
+	c.stdoutTees = append(c.stdoutTees, w)
+	return c
+}
+
+// TeeToPath adds a log file that receives a copy of both stdout and
+// stderr as they arrive. The file is created (or truncated) each time
+// that the command is run.
+func (c *ExecCmd) TeeToPath(p *Path) *ExecCmd {
+	c.teePaths = append(c.teePaths, p)
+	return c
+}
+
+// streamWriters adds the line handlers and tees to the given stdout and
+// stderr writer lists. The returned function must be called once the
+// command has completed to flush partial lines and close log files.
+func (c *ExecCmd) streamWriters(outs, errs []io.Writer) ([]io.Writer, []io.Writer, func(), error) {
+	var files	[]*os.File
+	var lines	[]*lineWriter
+
+	finish := func() {
+		for _, lw := range lines {
+			lw.Flush()
+		}
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	for _, f := range c.stdoutLines {
+		lw := newLineWriter(f)
+		lines = append(lines, lw)
+		outs = append(outs, lw)
+	}
+	for _, f := range c.stderrLines {
+		lw := newLineWriter(f)
+		lines = append(lines, lw)
+		errs = append(errs, lw)
+	}
+	outTees, errTees := sharedTees(c.stdoutTees, c.stderrTees)
+	outs = append(outs, outTees...)
+	errs = append(errs, errTees...)
+	for _, p := range c.teePaths {
+		f, err := os.Create(p.Absolute())
+		if err != nil {
+			finish()
+			return outs, errs, nil, err
+		}
+		files = append(files, f)
+		lw := &lockedWriter{w: f}
+		outs = append(outs, lw)
+		errs = append(errs, lw)
+	}
+
+	return outs, errs, finish, nil
+}
+
+// sharedTees returns the tees with each writer that receives both stdout
+// and stderr wrapped in a lockedWriter so that the two are not written
+// to it at once.
+func sharedTees(outs, errs []io.Writer) ([]io.Writer, []io.Writer) {
+	locked := map[io.Writer]io.Writer{}
+	for _, o := range outs {
+		// Writers which can not be compared can not be the same.
+		if (o == nil) || !reflect.TypeOf(o).Comparable() {
+			continue
+		}
+		for _, e := range errs {
+			if (e != nil) && reflect.TypeOf(e).Comparable() && (o == e) {
+				locked[o] = &lockedWriter{w: o}
+			}
+		}
+	}
+	if len(locked) == 0 {
+		return outs, errs
+	}
+
+	wrap := func(ws []io.Writer) []io.Writer {
+		wrapped := make([]io.Writer, len(ws))
+		for i, w := range ws {
+			wrapped[i] = w
+			if (w != nil) && reflect.TypeOf(w).Comparable() {
+				if lw, ok := locked[w]; ok {
+					wrapped[i] = lw
+				}
+			}
+		}
+		return wrapped
+	}
+	return wrap(outs), wrap(errs)
+}
+
+//----------------------------------------------------------------------------
+//                             Line Writer
+//----------------------------------------------------------------------------
+
+// lineWriter breaks up what is written to it into lines and calls
+// its handler for each complete line.
+type lineWriter struct {
+	buf			bytes.Buffer
+	handler		func(string)
+}
+
+func newLineWriter(f func(string)) *lineWriter {
+	lw := lineWriter{}
+	lw.handler = f
+	return &lw
+}
+
+// Flush passes any remaining partial line to the handler.
+func (l *lineWriter) Flush( ) {
+	if l.buf.Len() > 0 {
+		l.handler(strings.TrimSuffix(l.buf.String(), "\r"))
+		l.buf.Reset()
+	}
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			l.buf.Write(p)
+			break
+		}
+		l.buf.Write(p[:i])
+		p = p[i+1:]
+		l.handler(strings.TrimSuffix(l.buf.String(), "\r"))
+		l.buf.Reset()
+	}
+	return n, nil
+}
@@ -0,0 +1,118 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecLines(t *testing.T) {
+	var err 	error
+	var cmd		*ExecCmd
+	var mu		sync.Mutex
+	var outs	[]string
+	var errs	[]string
+	var first	time.Time
+
+	t.Log("TestExecLines()")
+
+	cmd = NewExecArgs("sh", "-c", "echo one; echo err1 >&2; sleep 0.5; printf 'two\\nthree'")
+	cmd.OnStdoutLine(func(s string) {
+		mu.Lock()
+		if len(outs) == 0 {
+			first = time.Now()
+		}
+		outs = append(outs, s)
+		mu.Unlock()
+	})
+	cmd.OnStderrLine(func(s string) {
+		mu.Lock()
+		errs = append(errs, s)
+		mu.Unlock()
+	})
+	r, err := cmd.RunResult()
+	if err != nil {
+		t.Fatalf("RunResult(sh) failed: %s\n", err.Error())
+	}
+	if len(outs) != 3 || outs[0] != "one" || outs[1] != "two" || outs[2] != "three" {
+		t.Errorf("stdout lines: %q\n", outs)
+	}
+	if len(errs) != 1 || errs[0] != "err1" {
+		t.Errorf("stderr lines: %q\n", errs)
+	}
+	if r.EndTime.Sub(first) < 250 * time.Millisecond {
+		t.Errorf("first line was not delivered live: %s before end\n", r.EndTime.Sub(first))
+	}
+	if string(r.Stdout) != "one\ntwo\nthree" {
+		t.Errorf("stdout was not captured: %q\n", r.Stdout)
+	}
+
+	t.Log("\tend: TestExecLines")
+}
+
+func TestExecTee(t *testing.T) {
+	var err 	error
+	var cmd		*ExecCmd
+	var out		bytes.Buffer
+	var lines	[]string
+
+	t.Log("TestExecTee()")
+
+	log := NewTempDir().Append("exec_tee_test.log")
+	defer log.DeleteFile()
+
+	cmd = NewExecArgs("sh", "-c", "echo out; echo err >&2")
+	cmd.TeeStdout(&out).TeeToPath(log)
+	cmd.OnStdoutLine(func(s string) {
+		lines = append(lines, s)
+	})
+	r, err := cmd.RunResult()
+	if err != nil {
+		t.Fatalf("RunResult(sh) failed: %s\n", err.Error())
+	}
+	if out.String() != "out\n" {
+		t.Errorf("TeeStdout: %q\n", out.String())
+	}
+	if len(lines) != 1 || lines[0] != "out" {
+		t.Errorf("stdout lines: %q\n", lines)
+	}
+	if r.StdoutString() != "out" || r.StderrString() != "err" {
+		t.Errorf("result output: %q %q\n", r.Stdout, r.Stderr)
+	}
+	text, err := ioutil.ReadFile(log.Absolute())
+	if err != nil {
+		t.Fatalf("TeeToPath(%s) failed: %s\n", log.String(), err.Error())
+	}
+	if !bytes.Contains(text, []byte("out\n")) || !bytes.Contains(text, []byte("err\n")) {
+		t.Errorf("TeeToPath(%s): %q\n", log.String(), text)
+	}
+
+	// A writer given to both streams is written to by one at a time.
+	var both	bytes.Buffer
+	cmd = NewExecArgs("sh", "-c", "i=0; while [ $i -lt 200 ]; do echo out; echo err >&2; i=$((i+1)); done")
+	if err = cmd.TeeStdout(&both).TeeStderr(&both).Run(); err != nil {
+		t.Fatalf("Run(sh) failed: %s\n", err.Error())
+	}
+	if both.Len() != 200 * 8 {
+		t.Errorf("TeeStdout and TeeStderr: %d bytes\n", both.Len())
+	}
+	outs, errs := sharedTees([]io.Writer{&both, &out}, []io.Writer{&both})
+	if _, ok := outs[0].(*lockedWriter); !ok || (outs[0] != errs[0]) || (outs[1] != &out) {
+		t.Errorf("sharedTees(): %#v %#v\n", outs, errs)
+	}
+
+	cmd = NewExecArgs("true").TeeToPath(NewPath("/xyzzy/not/there.log"))
+	if err = cmd.Run(); err == nil {
+		t.Errorf("TeeToPath(/xyzzy/not/there.log) should have failed!\n")
+	}
+
+	t.Log("\tend: TestExecTee")
+}
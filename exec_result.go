@@ -0,0 +1,72 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Execution Results
+
+// ExecResult captures everything that we know about one execution of
+// an ExecCmd so that callers can examine the output even when the
+// command fails.
+
+package util
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+//============================================================================
+//                             Execution Result
+//============================================================================
+
+// ExecResult is the outcome of running an ExecCmd once.
+type ExecResult struct {
+	Args		[]string			// Program and arguments executed
+	Cached		bool				// Replayed from an ExecCache, not run
+	DryRun		bool				// Recorded but not run (see ExecOptions)
+	Stdout		[]byte				// Everything written to stdout
+	Stderr		[]byte				// Everything written to stderr
+	ExitCode	int					// -1 if not started or killed
+	Signal		string				// Signal that ended the command if any
+	StartTime	time.Time
+	EndTime		time.Time
+	Duration	time.Duration
+	Usage		*ResourceUsage		// nil if the process did not run
+	Err			error				// nil if successful, else usually *ExecError
+}
+
+// StderrString returns stderr as a string with surrounding
+// whitespace removed.
+func (r *ExecResult) StderrString( ) string {
+	return strings.TrimSpace(string(r.Stderr))
+}
+
+// StdoutString returns stdout as a string with surrounding
+// whitespace removed.
+func (r *ExecResult) StdoutString( ) string {
+	return strings.TrimSpace(string(r.Stdout))
+}
+
+// Success returns true if the command ran and exited with
+// a status of zero.
+func (r *ExecResult) Success( ) bool {
+	return r.Err == nil && r.ExitCode == 0
+}
+
+//----------------------------------------------------------------------------
+//                             Locked Writer
+//----------------------------------------------------------------------------
+
+// lockedWriter serializes writes from the stdout and stderr copiers
+// when both of them share one destination.
+type lockedWriter struct {
+	mu			sync.Mutex
+	w			io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,68 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"testing"
+)
+
+func TestExecResult(t *testing.T) {
+	var err 	error
+	var cmd		*ExecCmd
+	var r		*ExecResult
+
+	t.Log("TestExecResult()")
+
+	cmd = NewExecArgs("sh", "-c", "echo out; echo err >&2; exit 3")
+	r, err = cmd.RunResult()
+	if err == nil {
+		t.Errorf("RunResult(sh) should have failed!\n")
+	}
+	if r == nil {
+		t.Fatalf("RunResult(sh) did not return a result!\n")
+	}
+	if r.Err != err {
+		t.Errorf("RunResult(sh) Err: %v, but returned %v\n", r.Err, err)
+	}
+	if r.StdoutString() != "out" {
+		t.Errorf("RunResult(sh) stdout: %q\n", r.Stdout)
+	}
+	if r.StderrString() != "err" {
+		t.Errorf("RunResult(sh) stderr: %q\n", r.Stderr)
+	}
+	if r.ExitCode != 3 || cmd.ExitCode() != 3 {
+		t.Errorf("RunResult(sh) exit code: %d %d\n", r.ExitCode, cmd.ExitCode())
+	}
+	if r.Success() {
+		t.Errorf("RunResult(sh) should not be successful!\n")
+	}
+	if r.Duration <= 0 || r.EndTime.Before(r.StartTime) {
+		t.Errorf("RunResult(sh) times: %s %s %s\n", r.StartTime, r.EndTime, r.Duration)
+	}
+	if cmd.Result() != r {
+		t.Errorf("Result() does not return the last result!\n")
+	}
+
+	cmd = NewExecArgs("echo", "hello")
+	r, err = cmd.RunResult()
+	if err != nil {
+		t.Errorf("RunResult(echo) failed: %s\n", err.Error())
+	}
+	if !r.Success() || r.StdoutString() != "hello" || len(r.Stderr) != 0 {
+		t.Errorf("RunResult(echo) result: %d %q %q\n", r.ExitCode, r.Stdout, r.Stderr)
+	}
+
+	cmd = NewExecArgs("./xyzzy_not_there")
+	r, err = cmd.RunResult()
+	if err == nil {
+		t.Errorf("RunResult(xyzzy) should have failed!\n")
+	}
+	if r.ExitCode != -1 {
+		t.Errorf("RunResult(xyzzy) exit code: %d\n", r.ExitCode)
+	}
+
+	t.Log("\tend: TestExecResult")
+}
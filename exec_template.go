@@ -0,0 +1,122 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Templates
+
+// NewExecTemplate builds a command from a text/template instead of by
+// concatenating strings. The template is executed with the SharedData
+// definitions (including cmd, dataPath, mainPath and outDir) as its
+// data and the SharedData functions available. The output of every
+// action is quoted (see QuoteArgPosix) before the result is split with
+// ParseCommandLine so that a value containing spaces or quotes is one
+// argument. A slice of strings becomes one argument per element. Since
+// the quoting is done for you, actions should not be placed within
+// quotes in the template. A definition that does not exist is an error
+// naming it rather than "<no value>".
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+//============================================================================
+//                             Command Templates
+//============================================================================
+
+// quoteTemplateValue quotes the output of a template action.
+func quoteTemplateValue(v interface{}) string {
+	switch a := v.(type) {
+	case nil:
+		return "''"
+	case string:
+		return QuoteArgPosix(a)
+	case []string:
+		q := make([]string, len(a))
+		for i, s := range a {
+			q[i] = QuoteArgPosix(s)
+		}
+		return strings.Join(q, " ")
+	case []interface{}:
+		q := make([]string, len(a))
+		for i, s := range a {
+			q[i] = QuoteArgPosix(fmt.Sprint(s))
+		}
+		return strings.Join(q, " ")
+	}
+	return QuoteArgPosix(fmt.Sprint(v))
+}
+
+// quoteTemplateActions adds "| shquote" to every action that outputs
+// a value in the list and the lists nested within it.
+func quoteTemplateActions(n parse.Node, quote *parse.CommandNode) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			quoteTemplateActions(c, quote)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) == 0 {
+			n.Pipe.Cmds = append(n.Pipe.Cmds, quote.Copy().(*parse.CommandNode))
+		}
+	case *parse.IfNode:
+		quoteTemplateActions(n.List, quote)
+		quoteTemplateActions(n.ElseList, quote)
+	case *parse.RangeNode:
+		quoteTemplateActions(n.List, quote)
+		quoteTemplateActions(n.ElseList, quote)
+	case *parse.WithNode:
+		quoteTemplateActions(n.List, quote)
+		quoteTemplateActions(n.ElseList, quote)
+	}
+}
+
+// NewExecTemplate returns the command produced by executing the template
+// with the given shared data which may be nil.
+func NewExecTemplate(tmpl string, sd *SharedData) (*ExecCmd, error) {
+	var b		strings.Builder
+
+	funcs := template.FuncMap{}
+	data := map[string]interface{}{}
+	if sd != nil {
+		for k, f := range sd.Funcs() {
+			funcs[k] = f
+		}
+		data = sd.Defns()
+	}
+	funcs["shquote"] = quoteTemplateValue
+
+	t, err := template.New("command").Funcs(funcs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("Error: NewExecTemplate: %s", err.Error())
+	}
+	q, err := template.New("quote").Funcs(funcs).Parse("{{. | shquote}}")
+	if err != nil {
+		return nil, err
+	}
+	quote := q.Tree.Root.Nodes[0].(*parse.ActionNode).Pipe.Cmds[1]
+	for _, tt := range t.Templates() {
+		if tt.Tree != nil {
+			quoteTemplateActions(tt.Tree.Root, quote)
+		}
+	}
+
+	if err = t.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("Error: NewExecTemplate: %s", err.Error())
+	}
+	args, err := ParseCommandLine(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("Error: NewExecTemplate: %q: %s", b.String(), err.Error())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("Error: NewExecTemplate: the template produced no command")
+	}
+	return NewExecArgs(args[0], args[1:]...), nil
+}
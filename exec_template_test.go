@@ -0,0 +1,53 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExecTemplate(t *testing.T) {
+	t.Log("TestExecTemplate()")
+
+	sd := &SharedData{}
+	sd.Init()
+	sd.SetCmd("gen")
+	sd.SetOutDir("/tmp/out dir")
+	sd.SetDefn("Title", `it's "here"`)
+	sd.SetDefn("Files", []string{"a b.go", "c.go"})
+	sd.SetFunc("upper", strings.ToUpper)
+
+	cmd, err := NewExecTemplate(
+		`echo {{.cmd}} -o {{.outDir}}/x --title={{.Title}} {{.Files}} {{upper .cmd}}` +
+		`{{if .Force}} -f{{end}}{{range .Files}} -i {{.}}{{end}}{{$n := "skip"}} {{$n}}`, sd)
+	if err != nil {
+		t.Fatalf("NewExecTemplate() failed: %s\n", err.Error())
+	}
+	want := []string{"echo", "gen", "-o", "/tmp/out dir/x", `--title=it's "here"`, "a b.go", "c.go", "GEN",
+						"-i", "a b.go", "-i", "c.go", "skip"}
+	if !equalArgs(cmd.Args(), want) {
+		t.Errorf("Args(): %q\n", cmd.Args())
+	}
+	if out, err := cmd.RunWithOutput(); err != nil || !strings.HasPrefix(out, "gen -o /tmp/out dir/x") {
+		t.Errorf("RunWithOutput(): %q %v\n", out, err)
+	}
+
+	// Errors name the field.
+	_, err = NewExecTemplate(`echo {{.outdir}}`, sd)
+	if err == nil || !strings.Contains(err.Error(), "outdir") {
+		t.Errorf("missing field: %v\n", err)
+	}
+	t.Logf("\t%s\n", ErrorString(err))
+	if _, err = NewExecTemplate(`echo {{.cmd`, sd); err == nil {
+		t.Errorf("bad template should have failed\n")
+	}
+	if _, err = NewExecTemplate(`{{if false}}x{{end}}`, nil); err == nil {
+		t.Errorf("empty command should have failed\n")
+	}
+
+	t.Log("\tend: TestExecTemplate")
+}
@@ -6,8 +6,11 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"log"
 	"testing"
+	"time"
 )
 
 func TestExecArgs(t *testing.T) {
@@ -34,6 +37,102 @@ func TestExecArgs(t *testing.T) {
 	t.Log("\tend: TestExecCmd")
 }
 
+func TestExecContext(t *testing.T) {
+	var err 	error
+	var cmd		*ExecCmd
+
+	t.Log("TestExecContext()")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd = NewExecArgs("sleep", "10")
+	time.AfterFunc(100 * time.Millisecond, cancel)
+	start := time.Now()
+	err = cmd.RunContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RunContext(sleep) should have been cancelled: %v\n", err)
+	}
+	if time.Since(start) > 5 * time.Second {
+		t.Errorf("RunContext(sleep) took %s to cancel\n", time.Since(start))
+	}
+
+	cmd = NewExecArgs("echo", "hello")
+	out, err := cmd.RunWithOutputContext(context.Background())
+	if err != nil {
+		t.Errorf("RunWithOutputContext(echo) failed: %s\n", err.Error())
+	}
+	if out != "hello" {
+		t.Errorf("RunWithOutputContext(echo) output: %q\n", out)
+	}
+
+	t.Log("\tend: TestExecContext")
+}
+
+func TestExecTimeout(t *testing.T) {
+	var err 	error
+	var cmd		*ExecCmd
+
+	t.Log("TestExecTimeout()")
+
+	cmd = NewExecArgs("sleep", "10").SetTimeout(100 * time.Millisecond)
+	start := time.Now()
+	err = cmd.Run()
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Run(sleep) should have timed out: %v\n", err)
+	}
+	if time.Since(start) > 5 * time.Second {
+		t.Errorf("Run(sleep) took %s to time out\n", time.Since(start))
+	}
+
+	// The shell ignores SIGTERM so it must be killed after the grace period.
+	cmd = NewExecArgs("sh", "-c", "trap '' TERM; while :; do sleep 1; done")
+	cmd.SetTimeout(100 * time.Millisecond).SetGracePeriod(200 * time.Millisecond)
+	start = time.Now()
+	_, err = cmd.RunWithOutput()
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("RunWithOutput(sh) should have timed out: %v\n", err)
+	}
+	if time.Since(start) > 5 * time.Second {
+		t.Errorf("RunWithOutput(sh) took %s to be killed\n", time.Since(start))
+	}
+
+	// A process started by the command still holds its output open after
+	// the command has been terminated. The output so far is kept.
+	cmd = NewExecArgs("sh", "-c", "echo started; sleep 30; echo done").SetTimeout(200 * time.Millisecond)
+	start = time.Now()
+	r, err := cmd.RunResult()
+	if !errors.Is(err, ErrTimeout) || r.StdoutString() != "started" {
+		t.Errorf("RunResult(sh sleep) should have timed out: %q %v\n", r.StdoutString(), err)
+	}
+	if time.Since(start) > 5 * time.Second {
+		t.Errorf("RunResult(sh sleep) took %s to time out\n", time.Since(start))
+	}
+
+	cmd = NewExecArgs("true").SetTimeout(5 * time.Second)
+	if err = cmd.Run(); err != nil {
+		t.Errorf("Run(true) failed: %s\n", err.Error())
+	}
+
+	t.Log("\tend: TestExecTimeout")
+}
+
+func TestExecRunTwice(t *testing.T) {
+
+	t.Log("TestExecRunTwice()")
+
+	cmd := NewExecArgs("echo", "again")
+	for i := 0; i < 2; i++ {
+		out, err := cmd.RunWithOutput()
+		if err != nil || out != "again" {
+			t.Errorf("RunWithOutput() %d: %q %v\n", i+1, out, err)
+		}
+	}
+	if cmd.Cmd().ProcessState == nil || cmd.Cmd().ProcessState.ExitCode() != 0 {
+		t.Errorf("Cmd().ProcessState was not set\n")
+	}
+
+	t.Log("\tend: TestExecRunTwice")
+}
+
 func TestParseCommand(t *testing.T) {
 	var err 	error
 	var args	[]string
@@ -93,7 +192,7 @@ func TestParseCommand(t *testing.T) {
 	if args[0] != "./program_name" {
 		t.Errorf("ParseCommand(cmd01) arg0: %s!\n", args[0])
 	}
-	if args[1] != "--debug='true'" {
+	if args[1] != "--debug=true" {
 		t.Errorf("ParseCommand(cmd01) arg1: %s!\n", args[1])
 	}
 	if args[2] != "-v" {
@@ -102,10 +201,105 @@ func TestParseCommand(t *testing.T) {
 	if args[3] != "1st_Arg" {
 		t.Errorf("ParseCommand(cmd01) arg3: %s!\n", args[3])
 	}
-	if args[4] != "\"2nd_Arg\"" {
+	if args[4] != "2nd_Arg" {
 		t.Errorf("ParseCommand(cmd01) arg4: %s!\n", args[4])
 	}
 
 	t.Log("\tend: TestParseCommand01")
 }
 
+
+func TestParseCommandPosix(t *testing.T) {
+	var test 	func (string,...string)
+
+	t.Log("TestParseCommandPosix()")
+	test = func(cmd string, expected ...string) {
+		args, err := ParseCommandLine(cmd)
+		if err != nil {
+			t.Errorf("ParseCommandLine(%q) error: %s\n", cmd, err.Error())
+			return
+		}
+		if len(args) != len(expected) {
+			t.Errorf("ParseCommandLine(%q) = %q, but should be %q!\n", cmd, args, expected)
+			return
+		}
+		for i := range args {
+			if args[i] != expected[i] {
+				t.Errorf("ParseCommandLine(%q) = %q, but should be %q!\n", cmd, args, expected)
+				return
+			}
+		}
+	}
+
+	test("  a\tb\n c  ", "a", "b", "c")
+	test(`'a b' "c d"`, "a b", "c d")
+	test(`'it''s' a"b"'c'd`, "its", "abcd")
+	test(`'' ""`, "", "")
+	test(`'\$x "y"'`, `\$x "y"`)
+	test(`"a\"b\\c\$d\e"`, `a"b\c$d\e`)
+	test(`a\ b\'c\"d`, `a b'c"d`)
+	test("a\\\nb c", "ab", "c")
+	test("\"a\\\nb\" c", "ab", "c")
+	test("'a\nb'", "a\nb")
+	test("\\\n")
+
+	t.Log("\tend: TestParseCommandPosix")
+}
+
+func TestParseCommandErrors(t *testing.T) {
+	var test 	func (string,int,int)
+
+	t.Log("TestParseCommandErrors()")
+	test = func(cmd string, line, col int) {
+		_, err := ParseCommandLine(cmd)
+		if err == nil {
+			t.Errorf("ParseCommandLine(%q) should have failed!\n", cmd)
+			return
+		}
+		t.Logf("\t%q: %s\n", cmd, err.Error())
+		var cle *CommandLineError
+		if !errors.As(err, &cle) {
+			t.Errorf("ParseCommandLine(%q) error type: %T\n", cmd, err)
+			return
+		}
+		if cle.Loc.LineNo != line || cle.Loc.ColNo != col {
+			t.Errorf("ParseCommandLine(%q) location: %d:%d, but should be %d:%d!\n",
+						cmd, cle.Loc.LineNo, cle.Loc.ColNo, line, col)
+		}
+	}
+
+	test(`abc 'def`, 1, 5)
+	test(`abc "def\"`, 1, 5)
+	test("abc\\\n  x \"y", 2, 5)
+	test(`abc\`, 1, 4)
+
+	cmd := NewExec()
+	if err := cmd.SetCommandString(`echo "oops`); err == nil {
+		t.Errorf("SetCommandString(unterminated) should have failed!\n")
+	}
+	if err := cmd.SetCommandString("  "); err == nil {
+		t.Errorf("SetCommandString(empty) should have failed!\n")
+	}
+	if err := cmd.SetCommandString(`echo "hello  world"`); err != nil {
+		t.Fatalf("SetCommandString(echo) failed: %s\n", err.Error())
+	}
+	out, err := cmd.RunWithOutput()
+	if err != nil {
+		t.Errorf("RunWithOutput(echo) failed: %s\n", err.Error())
+	}
+	if out != "hello  world" {
+		t.Errorf("RunWithOutput(echo) output: %q\n", out)
+	}
+
+	// The program that it replaces not being found is forgotten, but
+	// the directory is kept.
+	cmd = NewExecArgs("xyzzy_not_there").Dir(NewPath("/"))
+	if err = cmd.SetCommandString("pwd"); err != nil {
+		t.Fatalf("SetCommandString(pwd) failed: %s\n", err.Error())
+	}
+	if out, err = cmd.RunWithOutput(); err != nil || out != "/" {
+		t.Errorf("RunWithOutput(pwd) after xyzzy_not_there: %q %v\n", out, err)
+	}
+
+	t.Log("\tend: TestParseCommandErrors")
+}
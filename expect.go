@@ -0,0 +1,308 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Interactive Sessions
+
+// A Session drives a command that prompts for its input in the style
+// of expect(1). Text is sent to the command's stdin with Send and its
+// output (stdout and stderr together) is searched with Expect which
+// waits until a regular expression matches or a timeout expires. The
+// output up to the end of each match is consumed so that the next
+// Expect only sees what follows it. Everything sent and received is
+// kept in a transcript for debugging. The command's stdin is a pipe,
+// not a terminal, so programs that insist on a terminal can not be
+// driven this way.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+//============================================================================
+//                             Expect Errors
+//============================================================================
+
+// DefaultExpectTimeout is the time that Expect waits if no timeout is
+// given.
+var DefaultExpectTimeout = 10 * time.Second
+
+// ErrExpectTimeout is returned when the output did not match before
+// the timeout expired. Unlike ErrTimeout, the command is left running.
+var ErrExpectTimeout = errors.New("expect: timeout expired")
+
+// ExpectError describes an Expect that did not match. Err is
+// ErrExpectTimeout if the timeout expired or io.EOF if the command
+// ended first.
+type ExpectError struct {
+	Pattern		string			// Regular expression expected, "" == EOF
+	Timeout		time.Duration
+	Unmatched	string			// Output received but not yet matched
+	Err			error
+}
+
+// Error returns a one line description including the unmatched output.
+func (e *ExpectError) Error( ) string {
+	what := "EOF"
+	if e.Pattern != "" {
+		what = fmt.Sprintf("%q", e.Pattern)
+	}
+	why := "command ended"
+	if e.Err == ErrExpectTimeout {
+		why = fmt.Sprintf("timed out after %s", e.Timeout)
+	}
+	return fmt.Sprintf("Error: expect %s: %s; unmatched output: %q", what, why, e.Unmatched)
+}
+
+func (e *ExpectError) Unwrap( ) error {
+	return e.Err
+}
+
+//============================================================================
+//                             Session
+//============================================================================
+
+// Session is an interactive command. Send and Expect may be called
+// from different goroutines, but each should only be called from one
+// at a time.
+type Session struct {
+	mu			sync.Mutex
+	buf			[]byte				// Output not yet matched
+	cancel		context.CancelFunc
+	changed		chan struct{}		// Closed when buf or done changes
+	cmd			*ExecCmd
+	done		chan struct{}		// Closed when the command has ended
+	result		*ExecResult
+	stdin		*os.File
+	timeout		time.Duration		// 0 == DefaultExpectTimeout
+	transcript	bytes.Buffer
+	tlog		io.Writer			// Copy of the transcript, nil == none
+}
+
+// sessionWriter receives the command's output.
+type sessionWriter struct {
+	s			*Session
+}
+
+func (w sessionWriter) Write(p []byte) (int, error) {
+	s := w.s
+	s.mu.Lock()
+	s.buf = append(s.buf, p...)
+	s.log(p)
+	s.notify()
+	s.mu.Unlock()
+	return len(p), nil
+}
+
+// log adds to the transcript. The lock must be held.
+func (s *Session) log(p []byte) {
+	s.transcript.Write(p)
+	if s.tlog != nil {
+		s.tlog.Write(p)
+	}
+}
+
+// notify wakes up any Expect that is waiting. The lock must be held.
+func (s *Session) notify( ) {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Cmd returns the command being driven.
+func (s *Session) Cmd( ) *ExecCmd {
+	return s.cmd
+}
+
+// Close closes the command's stdin and waits for it to end. If it does
+// not end within its grace period, it is terminated. The command's
+// error (see ExecCmd.RunResult) is returned which wraps
+// context.Canceled if it had to be terminated.
+func (s *Session) Close( ) error {
+	if s.stdin == nil {
+		return nil
+	}
+	s.stdin.Close()
+	timer := time.NewTimer(s.cmd.GracePeriod())
+	defer timer.Stop()
+	select {
+	case <-s.done:
+	case <-timer.C:
+		s.cancel()
+		<-s.done
+	}
+	return s.Result().Err
+}
+
+// CloseStdin closes the command's stdin so that it reads end of file.
+func (s *Session) CloseStdin( ) error {
+	if s.stdin == nil {
+		return nil
+	}
+	return s.stdin.Close()
+}
+
+// Expect waits until the regular expression matches the output not yet
+// matched and returns the match and its subexpressions. The output up
+// to the end of the match is consumed. If timeout is zero, the session's
+// timeout is used. If the command ends or the timeout expires first, an
+// *ExpectError is returned.
+func (s *Session) Expect(re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	timeout = s.expectTimeout(timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		if loc := re.FindSubmatchIndex(s.buf); loc != nil {
+			m := make([]string, len(loc)/2)
+			for i := range m {
+				if loc[2*i] >= 0 {
+					m[i] = string(s.buf[loc[2*i]:loc[2*i+1]])
+				}
+			}
+			s.buf = append([]byte{}, s.buf[loc[1]:]...)
+			s.mu.Unlock()
+			return m, nil
+		}
+		ch := s.changed
+		ended := (s.result != nil)
+		unmatched := string(s.buf)
+		s.mu.Unlock()
+
+		if ended {
+			return nil, &ExpectError{Pattern: re.String(), Timeout: timeout, Unmatched: unmatched, Err: io.EOF}
+		}
+		select {
+		case <-ch:
+		case <-timer.C:
+			return nil, &ExpectError{Pattern: re.String(), Timeout: timeout, Unmatched: unmatched, Err: ErrExpectTimeout}
+		}
+	}
+}
+
+// ExpectEOF waits for the command to end within the session's timeout
+// and returns its error (see ExecCmd.RunResult). If it does not end in
+// time, an *ExpectError is returned and the command is left running.
+func (s *Session) ExpectEOF( ) error {
+	timeout := s.expectTimeout(0)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-s.done:
+		return s.result.Err
+	case <-timer.C:
+		return &ExpectError{Timeout: timeout, Unmatched: s.Unmatched(), Err: ErrExpectTimeout}
+	}
+}
+
+// expectTimeout returns the timeout to be used for an expect.
+func (s *Session) expectTimeout(d time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	if s.timeout > 0 {
+		return s.timeout
+	}
+	return DefaultExpectTimeout
+}
+
+// Result returns the result of the command once it has ended or nil
+// if it is still running.
+func (s *Session) Result( ) *ExecResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.result
+}
+
+// Send writes the text to the command's stdin. Nothing is added to it
+// so a line must end with "\n". Once the command (and anything that it
+// started) has closed its stdin, Send fails rather than waiting for it
+// to read.
+func (s *Session) Send(text string) error {
+	s.mu.Lock()
+	s.log([]byte(text))
+	s.mu.Unlock()
+	_, err := io.WriteString(s.stdin, text)
+	return err
+}
+
+// SetTimeout sets the time that Expect and ExpectEOF wait by default.
+// Zero selects DefaultExpectTimeout.
+func (s *Session) SetTimeout(d time.Duration) *Session {
+	s.timeout = d
+	return s
+}
+
+// SetTranscript sets a writer that receives a copy of the transcript as
+// it is written.
+func (s *Session) SetTranscript(w io.Writer) *Session {
+	s.mu.Lock()
+	s.tlog = w
+	s.mu.Unlock()
+	return s
+}
+
+// Start starts the command. The context applies to the whole session.
+func (s *Session) Start(ctx context.Context) error {
+	var err		error
+	var stdinR	*os.File
+
+	if s.stdin != nil {
+		return fmt.Errorf("Error: session already started: %s", s.cmd.CommandString())
+	}
+	if stdinR, s.stdin, err = os.Pipe(); err != nil {
+		return err
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	w := sessionWriter{s}
+	// Our copy of the read end is closed once the command has started.
+	s.cmd.stdin = func() (io.Reader, func(), error) {
+		return stdinR, func() { stdinR.Close() }, nil
+	}
+	s.cmd.TeeStdout(w).TeeStderr(w)
+
+	go func() {
+		r, _ := s.cmd.RunResultContext(ctx)
+		stdinR.Close()
+		s.cancel()
+		s.mu.Lock()
+		s.result = r
+		s.notify()
+		s.mu.Unlock()
+		close(s.done)
+	}()
+	return nil
+}
+
+// Transcript returns everything sent and received so far.
+func (s *Session) Transcript( ) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transcript.String()
+}
+
+// Unmatched returns the output received but not yet matched.
+func (s *Session) Unmatched( ) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.buf)
+}
+
+// NewSession returns a session that drives the command once started.
+// The session sets the command's stdin and adds its own output tees so
+// the command should not be used elsewhere.
+func NewSession(cmd *ExecCmd) *Session {
+	s := &Session{cmd: cmd}
+	s.changed = make(chan struct{})
+	s.done = make(chan struct{})
+	return s
+}
@@ -0,0 +1,135 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// questionScript stands in for an interactive program.
+func questionScript() *ExecCmd {
+	script := `printf 'Name? '; read name; echo "Hello, $name"; printf 'Continue [y/n]? '; read ans; ` +
+				`if [ "$ans" = y ]; then echo done; exit 0; fi; echo aborted >&2; exit 2`
+	return NewExecArgs("sh", "-c", script)
+}
+
+func TestSession(t *testing.T) {
+	var ee		*ExpectError
+	var log		bytes.Buffer
+
+	t.Log("TestSession()")
+
+	s := NewSession(questionScript()).SetTimeout(5 * time.Second).SetTranscript(&log)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() failed: %s\n", err.Error())
+	}
+	defer s.Close()
+	if _, err := s.Expect(regexp.MustCompile(`Name\? $`), 0); err != nil {
+		t.Fatalf("Expect(Name) failed: %s\n", err.Error())
+	}
+	s.Send("Bob\n")
+	m, err := s.Expect(regexp.MustCompile(`Hello, (\w+)`), 0)
+	if err != nil || len(m) != 2 || m[1] != "Bob" {
+		t.Fatalf("Expect(Hello) failed: %q %v\n", m, err)
+	}
+
+	// A timeout reports what was received but not matched.
+	_, err = s.Expect(regexp.MustCompile(`never`), 100 * time.Millisecond)
+	if !errors.As(err, &ee) || !errors.Is(err, ErrExpectTimeout) || errors.Is(err, ErrTimeout) ||
+			!strings.Contains(ee.Unmatched, "Continue [y/n]? ") {
+		t.Fatalf("Expect(never): %#v\n", err)
+	}
+	if !strings.Contains(err.Error(), "Continue [y/n]?") {
+		t.Errorf("timeout message: %s\n", err.Error())
+	}
+	t.Logf("\t%s\n", err.Error())
+
+	if _, err = s.Expect(regexp.MustCompile(`\[y/n\]\? `), 0); err != nil {
+		t.Fatalf("Expect(y/n) failed: %s\n", err.Error())
+	}
+	s.Send("y\n")
+	if _, err = s.Expect(regexp.MustCompile(`done\n`), 0); err != nil {
+		t.Fatalf("Expect(done) failed: %s\n", err.Error())
+	}
+	if err = s.ExpectEOF(); err != nil {
+		t.Fatalf("ExpectEOF() failed: %s\n", err.Error())
+	}
+	want := "Name? Bob\nHello, Bob\nContinue [y/n]? y\ndone\n"
+	if s.Transcript() != want || log.String() != want {
+		t.Errorf("transcript: %q %q\n", s.Transcript(), log.String())
+	}
+	if s.Result() == nil || s.Result().ExitCode != 0 {
+		t.Errorf("Result(): %#v\n", s.Result())
+	}
+
+	t.Log("\tend: TestSession")
+}
+
+func TestSessionEnd(t *testing.T) {
+	var ee		*ExpectError
+
+	t.Log("TestSessionEnd()")
+
+	// The command ending before a match is reported with its output.
+	s := NewSession(questionScript()).SetTimeout(5 * time.Second)
+	s.Start(context.Background())
+	defer s.Close()
+	s.Send("Ann\nn\n")
+	_, err := s.Expect(regexp.MustCompile(`done`), 0)
+	if !errors.As(err, &ee) || !errors.Is(err, io.EOF) || !strings.Contains(ee.Unmatched, "aborted") {
+		t.Fatalf("Expect(done): %#v\n", err)
+	}
+	if err = s.ExpectEOF(); err == nil || s.Result().ExitCode != 2 {
+		t.Errorf("ExpectEOF() should have failed: %v\n", err)
+	}
+
+	// Close ends a command that is still waiting for input.
+	s = NewSession(questionScript())
+	s.Start(context.Background())
+	s.Expect(regexp.MustCompile(`Name\? `), 5 * time.Second)
+	if err = s.Close(); err == nil || s.Result() == nil || s.Result().ExitCode != 2 {
+		t.Errorf("Close() did not wait for the command: %v\n", err)
+	}
+
+	// A command that ignores the end of its input is terminated.
+	cmd := NewExecArgs("sh", "-c", "exec sleep 30").SetGracePeriod(100 * time.Millisecond)
+	s = NewSession(cmd)
+	s.Start(context.Background())
+	if err = s.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close() should have been cancelled: %v\n", err)
+	}
+
+	// Sending to a command that no longer reads its input fails rather
+	// than blocking.
+	cmd = NewExecArgs("sh", "-c", "exec <&-; echo closed; exec sleep 30")
+	s = NewSession(cmd.SetGracePeriod(100 * time.Millisecond))
+	s.Start(context.Background())
+	defer s.Close()
+	if _, err = s.Expect(regexp.MustCompile(`closed`), 5 * time.Second); err != nil {
+		t.Fatalf("Expect(closed) failed: %s\n", err.Error())
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- s.Send(strings.Repeat("x", 1 << 20))
+	}()
+	select {
+	case err = <-sent:
+		if err == nil {
+			t.Errorf("Send() to a command not reading should have failed\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Send() to a command not reading blocked\n")
+	}
+
+	t.Log("\tend: TestSessionEnd")
+}
@@ -1,5 +1,5 @@
 module github.com/2kranki/go_util
 
-go 1.12
+go 1.13
 
 require github.com/2kranki/jsonpreprocess v1.0.1
@@ -13,6 +13,7 @@ package util
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -24,7 +25,11 @@ import (
 //                             		Path
 //============================================================================
 
-// Path provides a centralized
+// Path provides a centralized way of handling file paths. A Path is an
+// immutable value. Its methods never modify it and any that produce a
+// different path return a new Path so a *Path may be shared freely,
+// including between goroutines. "~" and environment variables are only
+// expanded in what is returned (see Clean, ExpandHome and ExpandEnv).
 type Path struct {
 	str       	string
 }
@@ -65,21 +70,37 @@ func (p *Path) Chmod(mode os.FileMode) error {
 	return err
 }
 
-// Clean cleans up the file path. It returns the absolute
-// file path if needed.
+// Clean returns the absolute file path after expanding a leading "~"
+// and any environment variables. The path itself is not changed.
 func (p *Path) Clean( ) string {
 	var path string
 
-	if strings.HasPrefix(p.str, "~") {
-		p.str = NewHomeDir().String() + string(os.PathSeparator) + p.str[1:]
-	}
-	p.str = os.ExpandEnv(p.str)
-	p.str = filepath.Clean(p.str)
-	path, _ = filepath.Abs(p.str)
+	path, _ = filepath.Abs(p.ExpandHome().ExpandEnv().str)
 
 	return path
 }
 
+// CommonAncestor returns the longest path whose elements begin this
+// path and all of the given ones (see Parts). Relative paths with no
+// elements in common give ".". If absolute and relative paths are
+// mixed, they have no common ancestor and false is returned. The paths
+// are compared as written without looking at the file system.
+func (p *Path) CommonAncestor(paths ...*Path) (*Path, bool) {
+	root, elems := splitPath(p.str)
+	for _, o := range paths {
+		r, e := splitPath(o.str)
+		if r != root {
+			return nil, false
+		}
+		n := 0
+		for (n < len(elems)) && (n < len(e)) && (elems[n] == e[n]) {
+			n++
+		}
+		elems = elems[:n]
+	}
+	return joinPath(root, elems), true
+}
+
 // Copy creates a new copy of the path.
 func (p *Path) Copy( ) *Path {
 	pth := Path{}
@@ -123,6 +144,13 @@ func (p *Path) DeleteFile( ) error {
 	return err
 }
 
+// Depth returns the number of elements in the path not counting the
+// root directory so "/" and "." are 0 and "/a/b" and "../a" are 2.
+func (p *Path) Depth( ) int {
+	_, elems := splitPath(p.str)
+	return len(elems)
+}
+
 // Dir returns everything but the last component of the path.
 // If the directory portion is empty, then '.' is returned.
 func (p *Path) Dir( ) string {
@@ -133,6 +161,21 @@ func (p *Path) Dir( ) string {
 	return b
 }
 
+// Exists returns true if the path exists. A path that does not exist
+// returns false without an error so that any error returned, such as
+// permission being denied, is a real failure to find out. A symbolic
+// link whose target is missing exists.
+func (p *Path) Exists( ) (bool, error) {
+	_, err := p.Lstat()
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
 // Expand replaces ${var} or $var in the given path based on the
 // mapping function returning a new path.
 func (p *Path) Expand(mapping func(string) string) *Path {
@@ -142,6 +185,106 @@ func (p *Path) Expand(mapping func(string) string) *Path {
 	return pth
 }
 
+// ExpandEnv returns a new path with ${var} or $var replaced by the
+// values of the environment variables.
+func (p *Path) ExpandEnv( ) *Path {
+	return p.Expand(os.Getenv)
+}
+
+// ExpandHome returns a new path with a leading "~" replaced by the
+// current user's home directory or "~name" by the home directory of
+// the named user. If there is no such user, the path is unchanged.
+func (p *Path) ExpandHome( ) *Path {
+	if !strings.HasPrefix(p.str, "~") {
+		return p.Copy()
+	}
+	name := p.str[1:]
+	rest := ""
+	if i := strings.IndexAny(name, "/" + string(os.PathSeparator)); i >= 0 {
+		name, rest = name[:i], name[i+1:]
+	}
+	home := ""
+	if name == "" {
+		home = NewHomeDir().String()
+	} else if usr, err := user.Lookup(name); err == nil {
+		home = usr.HomeDir
+	}
+	if home == "" {
+		return p.Copy()
+	}
+	return NewPath(filepath.Join(home, rest))
+}
+
+// Ext returns the extension of the last element of the cleaned path
+// including its dot or "" if there is none. Leading dots, such as in
+// ".profile" or "..", do not start an extension.
+func (p *Path) Ext( ) string {
+	return filepath.Ext(strings.TrimLeft(p.lastElement(), "."))
+}
+
+// HasPrefixPath returns true if the path is other or is within other
+// comparing whole elements so "a/bc" is not within "a/b". The paths
+// are compared as written without looking at the file system.
+func (p *Path) HasPrefixPath(other *Path) bool {
+	root, elems := splitPath(p.str)
+	oRoot, oElems := splitPath(other.str)
+	if (root != oRoot) || (len(oElems) > len(elems)) {
+		return false
+	}
+	if (oRoot == "") && (len(oElems) == 0) && (len(elems) > 0) && (elems[0] == "..") {
+		return false
+	}
+	for i := range oElems {
+		if elems[i] != oElems[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsAbs returns true if the path is absolute.
+func (p *Path) IsAbs( ) bool {
+	return filepath.IsAbs(p.str)
+}
+
+// IsEmptyDir returns true if the path is a directory without any
+// entries. Anything else that exists returns false.
+func (p *Path) IsEmptyDir( ) (bool, error) {
+	fi, err := p.Stat()
+	if err != nil || !fi.IsDir() {
+		return false, err
+	}
+	dir, err := os.Open(p.Clean())
+	if err != nil {
+		return false, err
+	}
+	defer dir.Close()
+	if _, err = dir.Readdirnames(1); err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// IsExecutable returns true if the path (after following symbolic
+// links) is a regular file that someone may execute.
+func (p *Path) IsExecutable( ) (bool, error) {
+	fi, err := p.Stat()
+	if err != nil {
+		return false, err
+	}
+	return fi.Mode().IsRegular() && (fi.Mode().Perm() & 0111 != 0), nil
+}
+
+// IsNamedPipe returns true if the path (after following symbolic links)
+// is a named pipe (FIFO).
+func (p *Path) IsNamedPipe( ) (bool, error) {
+	fi, err := p.Stat()
+	if err != nil {
+		return false, err
+	}
+	return fi.Mode() & os.ModeNamedPipe != 0, nil
+}
+
 // IsPathDir cleans up the supplied file path
 // and then checks the cleaned file path to see
 // if it is an existing standard directory.
@@ -178,6 +321,38 @@ func (p *Path) IsPathRegularFile( ) bool {
 	return false
 }
 
+// IsSocket returns true if the path (after following symbolic links)
+// is a Unix domain socket.
+func (p *Path) IsSocket( ) (bool, error) {
+	fi, err := p.Stat()
+	if err != nil {
+		return false, err
+	}
+	return fi.Mode() & os.ModeSocket != 0, nil
+}
+
+// IsSymlink returns true if the path itself is a symbolic link.
+func (p *Path) IsSymlink( ) (bool, error) {
+	fi, err := p.Lstat()
+	if err != nil {
+		return false, err
+	}
+	return fi.Mode() & os.ModeSymlink != 0, nil
+}
+
+// Join returns a new path with the given elements added to the end.
+func (p *Path) Join(elems ...string) *Path {
+	return NewPath(filepath.Join(append([]string{p.str}, elems...)...))
+}
+
+// Lstat returns the file information of the path without following a
+// symbolic link.
+func (p *Path) Lstat( ) (os.FileInfo, error) {
+	return os.Lstat(p.Clean())
+}
+
+// Mode returns the mode of the file or 0 if it can not be found (see
+// Stat).
 func (p *Path) Mode( ) os.FileMode {
 	var mode	os.FileMode
 
@@ -188,6 +363,8 @@ func (p *Path) Mode( ) os.FileMode {
 	return mode
 }
 
+// ModTime returns the modification time of the file or the zero time
+// if it can not be found (see Stat).
 func (p *Path) ModTime( ) time.Time {
 	var mod		time.Time
 
@@ -198,6 +375,40 @@ func (p *Path) ModTime( ) time.Time {
 	return mod
 }
 
+// Parent returns the directory containing the path. The parent of the
+// root directory is itself, the parent of "." is ".." and the parent of
+// ".." is "../..".
+func (p *Path) Parent( ) *Path {
+	root, elems := splitPath(p.str)
+	if (len(elems) == 0) || (elems[len(elems)-1] == "..") {
+		if root != "" {
+			return NewPath(root)
+		}
+		return joinPath(root, append(elems, ".."))
+	}
+	return joinPath(root, elems[:len(elems)-1])
+}
+
+// Parts returns the elements of the cleaned path. An absolute path's
+// first element is its root directory such as "/". "." has none.
+func (p *Path) Parts( ) []string {
+	root, elems := splitPath(p.str)
+	if root != "" {
+		return append([]string{root}, elems...)
+	}
+	return elems
+}
+
+// Rel returns the path relative to base such that base.Join(rel) is
+// the path. Both must be absolute or both relative.
+func (p *Path) Rel(base *Path) (*Path, error) {
+	rel, err := filepath.Rel(base.str, p.str)
+	if err != nil {
+		return nil, err
+	}
+	return NewPath(rel), nil
+}
+
 // RemoveDir assumes that this path represents a
 // directory and deletes it along with any parent
 // directories that it can as well.
@@ -212,11 +423,8 @@ func (p *Path) RemoveDir( ) error {
 	return err
 }
 
-func (p *Path) SetStr(s string) {
-	p.str = s
-}
-
-// Size returns length in bytes for regular files.
+// Size returns length in bytes for regular files or 0 if the file can
+// not be found (see Stat).
 func (p *Path) Size( ) int64 {
 	var size	int64
 
@@ -227,10 +435,79 @@ func (p *Path) Size( ) int64 {
 	return size
 }
 
+// Stat returns the file information of the path following any symbolic
+// links.
+func (p *Path) Stat( ) (os.FileInfo, error) {
+	return os.Stat(p.Clean())
+}
+
+// Stem returns the last element of the cleaned path without its
+// extension.
+func (p *Path) Stem( ) string {
+	return strings.TrimSuffix(p.lastElement(), p.Ext())
+}
+
 func (p *Path) String( ) string {
 	return p.str
 }
 
+// WithBase returns a new path with the last element replaced by name.
+// A path without a last element (the root directory, "." or "..") is
+// returned unchanged.
+func (p *Path) WithBase(name string) *Path {
+	root, elems := splitPath(p.str)
+	if (len(elems) == 0) || (elems[len(elems)-1] == "..") {
+		return p.Copy()
+	}
+	elems = append(append([]string{}, elems[:len(elems)-1]...), name)
+	return joinPath(root, elems)
+}
+
+// WithExt returns a new path with the extension of the last element
+// replaced by ext which may omit its leading dot. An empty ext removes
+// the extension.
+func (p *Path) WithExt(ext string) *Path {
+	if (ext != "") && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return p.WithBase(p.Stem() + ext)
+}
+
+// lastElement returns the last element of the cleaned path, the root
+// directory or "." if there is none.
+func (p *Path) lastElement( ) string {
+	return filepath.Base(filepath.Clean(p.str))
+}
+
+// joinPath builds a path from a root directory ("" == relative) and
+// its elements.
+func joinPath(root string, elems []string) *Path {
+	if len(elems) == 0 {
+		if root == "" {
+			return NewPath(".")
+		}
+		return NewPath(root)
+	}
+	return NewPath(root + filepath.Join(elems...))
+}
+
+// splitPath splits the cleaned path into its root directory (including
+// any volume name) and its elements.
+func splitPath(s string) (string, []string) {
+	c := filepath.Clean(s)
+	vol := filepath.VolumeName(c)
+	rest := c[len(vol):]
+	root := vol
+	if strings.HasPrefix(rest, string(os.PathSeparator)) {
+		root += string(os.PathSeparator)
+		rest = rest[1:]
+	}
+	if (rest == "") || (rest == ".") {
+		return root, nil
+	}
+	return root, strings.Split(rest, string(os.PathSeparator))
+}
+
 // NewHomeDir returns the current working directory as a Path.
 func NewHomeDir() *Path {
 	p := Path{}
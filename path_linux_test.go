@@ -0,0 +1,62 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestPathSpecialFiles(t *testing.T) {
+	t.Log("TestPathSpecialFiles()")
+
+	dir := NewTempDir().Append("path_special_test")
+	dir.RemoveDir()
+	if err := dir.CreateDir(); err != nil {
+		t.Fatalf("CreateDir() failed: %s\n", err.Error())
+	}
+	defer dir.RemoveDir()
+
+	fifo := dir.Append("fifo")
+	if err := syscall.Mkfifo(fifo.Absolute(), 0644); err != nil {
+		t.Fatalf("Mkfifo() failed: %s\n", err.Error())
+	}
+	if ok, err := fifo.IsNamedPipe(); !ok || err != nil {
+		t.Errorf("IsNamedPipe(fifo): %v %v\n", ok, err)
+	}
+	if ok, err := fifo.IsSocket(); ok || err != nil {
+		t.Errorf("IsSocket(fifo): %v %v\n", ok, err)
+	}
+
+	sock := dir.Append("sock")
+	ln, err := net.Listen("unix", sock.Absolute())
+	if err != nil {
+		t.Fatalf("Listen() failed: %s\n", err.Error())
+	}
+	defer ln.Close()
+	if ok, err := sock.IsSocket(); !ok || err != nil {
+		t.Errorf("IsSocket(sock): %v %v\n", ok, err)
+	}
+	if ok, err := sock.IsNamedPipe(); ok || err != nil {
+		t.Errorf("IsNamedPipe(sock): %v %v\n", ok, err)
+	}
+
+	// Permission denied is an error rather than not existing.
+	if os.Geteuid() != 0 {
+		locked := dir.Append("locked")
+		locked.CreateDir()
+		os.Chmod(locked.Absolute(), 0)
+		defer os.Chmod(locked.Absolute(), 0755)
+		ok, err := locked.Append("file").Exists()
+		if ok || err == nil || !os.IsPermission(err) {
+			t.Errorf("Exists(locked/file): %v %v\n", ok, err)
+		}
+	}
+
+	t.Log("\tend: TestPathSpecialFiles")
+}
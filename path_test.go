@@ -6,7 +6,10 @@
 package util
 
 import (
+	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -151,4 +154,345 @@ func TestPath(t *testing.T) {
 	t.Log("\tend: TestPathClean")
 }
 
+func TestPathExpand(t *testing.T) {
+	t.Log("TestPathExpand()")
 
+	home := NewHomeDir().String()
+	os.Setenv("PATH_TEST_DIR", "/a b/c")
+	defer os.Unsetenv("PATH_TEST_DIR")
+
+	tests := []struct {
+		input		string
+		home		string
+		env			string
+	}{
+		{"~", home, "~"},
+		{"~/x/y", home + "/x/y", "~/x/y"},
+		{"~no_such_user_xyz/x", "~no_such_user_xyz/x", "~no_such_user_xyz/x"},
+		{"$PATH_TEST_DIR/d", "$PATH_TEST_DIR/d", "/a b/c/d"},
+		{"a/${PATH_TEST_DIR}", "a/${PATH_TEST_DIR}", "a/a b/c"},
+		{"x/y", "x/y", "x/y"},
+	}
+	for _, tt := range tests {
+		p := NewPath(tt.input)
+		if s := p.ExpandHome().String(); s != tt.home {
+			t.Errorf("ExpandHome(%s) = %s, but should be %s\n", tt.input, s, tt.home)
+		}
+		if s := p.ExpandEnv().String(); s != tt.env {
+			t.Errorf("ExpandEnv(%s) = %s, but should be %s\n", tt.input, s, tt.env)
+		}
+		if p.String() != tt.input {
+			t.Errorf("Expand modified %s to %s\n", tt.input, p.String())
+		}
+	}
+	if s := NewPath("~/$PATH_TEST_DIR").Clean(); s != home + "/a b/c" {
+		t.Errorf("Clean(~/$PATH_TEST_DIR) = %s\n", s)
+	}
+
+	t.Log("\tend: TestPathExpand")
+}
+
+func TestPathImmutable(t *testing.T) {
+	var wg		sync.WaitGroup
+
+	t.Log("TestPathImmutable()")
+
+	os.Setenv("PATH_TEST_DIR", "test")
+	defer os.Unsetenv("PATH_TEST_DIR")
+	inputs := []string{"~", "~/.", "./$PATH_TEST_DIR", "${PATH_TEST_DIR}/../exec.go", "a//b/../c/"}
+
+	// Nothing that only asks about a path changes it.
+	for _, s := range inputs {
+		p := NewPath(s)
+		p.Clean()
+		p.Absolute()
+		p.IsPathDir()
+		p.IsPathRegularFile()
+		p.Mode()
+		p.ModTime()
+		p.Size()
+		p.Append("x")
+		p.ExpandHome()
+		p.ExpandEnv()
+		if p.String() != s {
+			t.Errorf("%s was changed to %s\n", s, p.String())
+		}
+	}
+
+	// Shared paths can be used by many goroutines at once.
+	paths := make([]*Path, len(inputs))
+	want := make([]string, len(inputs))
+	for i, s := range inputs {
+		paths[i] = NewPath(s)
+		want[i] = paths[i].Absolute()
+	}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				for i, p := range paths {
+					if p.Absolute() != want[i] || p.String() != inputs[i] {
+						t.Errorf("%s changed while shared\n", inputs[i])
+						return
+					}
+					p.IsPathDir()
+					p.Append("y").Dir()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if !NewPath("./$PATH_TEST_DIR").IsPathDir() {
+		t.Errorf("IsPathDir(./$PATH_TEST_DIR) should be true\n")
+	}
+
+	t.Log("\tend: TestPathImmutable")
+}
+
+func TestPathStat(t *testing.T) {
+	t.Log("TestPathStat()")
+
+	dir := NewTempDir().Append("path_stat_test")
+	dir.RemoveDir()
+	if err := dir.CreateDir(); err != nil {
+		t.Fatalf("CreateDir() failed: %s\n", err.Error())
+	}
+	defer dir.RemoveDir()
+	file := dir.Append("file.txt")
+	ioutil.WriteFile(file.Absolute(), []byte("12345"), 0644)
+	script := dir.Append("script.sh")
+	ioutil.WriteFile(script.Absolute(), []byte("#!/bin/sh\n"), 0755)
+	link := dir.Append("link")
+	os.Symlink(file.Absolute(), link.Absolute())
+	missing := dir.Append("missing")
+	empty := dir.Append("empty")
+	empty.CreateDir()
+
+	fi, err := file.Stat()
+	if err != nil || fi.Size() != 5 || !fi.Mode().IsRegular() {
+		t.Errorf("Stat(file): %v %v\n", fi, err)
+	}
+	if fi, err = link.Lstat(); err != nil || fi.Mode() & os.ModeSymlink == 0 {
+		t.Errorf("Lstat(link): %v %v\n", fi, err)
+	}
+	if _, err = missing.Stat(); !os.IsNotExist(err) {
+		t.Errorf("Stat(missing): %v\n", err)
+	}
+
+	check := func(name string, got bool, err error, want bool) {
+		if err != nil || got != want {
+			t.Errorf("%s = %v, %v but should be %v\n", name, got, err, want)
+		}
+	}
+	ok, err := file.Exists()
+	check("Exists(file)", ok, err, true)
+	ok, err = missing.Exists()
+	check("Exists(missing)", ok, err, false)
+	ok, err = link.IsSymlink()
+	check("IsSymlink(link)", ok, err, true)
+	ok, err = file.IsSymlink()
+	check("IsSymlink(file)", ok, err, false)
+	ok, err = script.IsExecutable()
+	check("IsExecutable(script)", ok, err, true)
+	ok, err = file.IsExecutable()
+	check("IsExecutable(file)", ok, err, false)
+	ok, err = dir.IsExecutable()
+	check("IsExecutable(dir)", ok, err, false)
+	ok, err = empty.IsEmptyDir()
+	check("IsEmptyDir(empty)", ok, err, true)
+	ok, err = dir.IsEmptyDir()
+	check("IsEmptyDir(dir)", ok, err, false)
+	ok, err = file.IsEmptyDir()
+	check("IsEmptyDir(file)", ok, err, false)
+	ok, err = file.IsSocket()
+	check("IsSocket(file)", ok, err, false)
+	ok, err = file.IsNamedPipe()
+	check("IsNamedPipe(file)", ok, err, false)
+
+	// Queries about missing paths say why they failed.
+	if _, err = missing.IsExecutable(); !os.IsNotExist(err) {
+		t.Errorf("IsExecutable(missing): %v\n", err)
+	}
+	if _, err = missing.IsSymlink(); !os.IsNotExist(err) {
+		t.Errorf("IsSymlink(missing): %v\n", err)
+	}
+
+	t.Log("\tend: TestPathStat")
+}
+
+func TestPathElements(t *testing.T) {
+	tests := []struct {
+		input		string
+		ext			string
+		stem		string
+		parent		string
+		parts		string		// Joined by "|"
+		depth		int
+		abs			bool
+	}{
+		{"/a/b/c.go", ".go", "c", "/a/b", "/|a|b|c.go", 3, true},
+		{"a/b.tar.gz", ".gz", "b.tar", "a", "a|b.tar.gz", 2, false},
+		{"a/b/", "", "b", "a", "a|b", 2, false},
+		{"a//b/./c/..", "", "b", "a", "a|b", 2, false},
+		{"/", "", "/", "/", "/", 0, true},
+		{"/..", "", "/", "/", "/", 0, true},
+		{".", "", ".", "..", "", 0, false},
+		{"", "", ".", "..", "", 0, false},
+		{"..", "", "..", "../..", "..", 1, false},
+		{"../a.txt", ".txt", "a", "..", "..|a.txt", 2, false},
+		{"a/..", "", ".", "..", "", 0, false},
+		{".profile", "", ".profile", ".", ".profile", 1, false},
+		{"x/..a.go", ".go", "..a", "x", "x|..a.go", 2, false},
+		{"file.", ".", "file", ".", "file.", 1, false},
+	}
+
+	t.Log("TestPathElements()")
+
+	for _, tt := range tests {
+		p := NewPath(tt.input)
+		if p.Ext() != tt.ext || p.Stem() != tt.stem {
+			t.Errorf("%q: Ext() = %q, Stem() = %q\n", tt.input, p.Ext(), p.Stem())
+		}
+		if p.Parent().String() != tt.parent {
+			t.Errorf("%q: Parent() = %q, but should be %q\n", tt.input, p.Parent().String(), tt.parent)
+		}
+		if parts := strings.Join(p.Parts(), "|"); parts != tt.parts {
+			t.Errorf("%q: Parts() = %q, but should be %q\n", tt.input, parts, tt.parts)
+		}
+		if p.Depth() != tt.depth || p.IsAbs() != tt.abs {
+			t.Errorf("%q: Depth() = %d, IsAbs() = %v\n", tt.input, p.Depth(), p.IsAbs())
+		}
+		if p.String() != tt.input {
+			t.Errorf("%q was changed to %q\n", tt.input, p.String())
+		}
+	}
+
+	t.Log("\tend: TestPathElements")
+}
+
+func TestPathWith(t *testing.T) {
+	tests := []struct {
+		input		string
+		ext			string
+		withExt		string
+		base		string
+		withBase	string
+		join		[]string
+		joined		string
+	}{
+		{"/a/b/c.go", ".txt", "/a/b/c.txt", "d", "/a/b/d", []string{"x", "y.z"}, "/a/b/c.go/x/y.z"},
+		{"a/b.tar.gz", "zip", "a/b.tar.zip", "c.d", "a/c.d", []string{"../c"}, "a/c"},
+		{"a/b/", "", "a/b", "c", "a/c", []string{}, "a/b"},
+		{"a/b.go", "", "a/b", "", "a", []string{"", "c"}, "a/b.go/c"},
+		{"/", ".go", "/", "x", "/", []string{"a"}, "/a"},
+		{".", ".go", ".", "x", ".", []string{".."}, ".."},
+		{"..", ".go", "..", "x", "..", []string{"a"}, "../a"},
+		{"../a", ".go", "../a.go", "b", "../b", []string{"/b"}, "../a/b"},
+		{".profile", ".bak", ".profile.bak", "x", "x", []string{"a"}, ".profile/a"},
+		{"a/b/c/..", ".go", "a/b.go", "x", "a/x", []string{"d"}, "a/b/d"},
+		{"a/b.txt/c/..", ".go", "a/b.go", "x", "a/x", []string{}, "a/b.txt"},
+		{"/a/..", ".go", "/a/..", "x", "/a/..", []string{"b"}, "/b"},
+		{"a/..", ".go", "a/..", "x", "a/..", []string{"b"}, "b"},
+		{"../..", ".go", "../..", "x", "../..", []string{"a"}, "../../a"},
+	}
+
+	t.Log("TestPathWith()")
+
+	for _, tt := range tests {
+		p := NewPath(tt.input)
+		if s := p.WithExt(tt.ext).String(); s != tt.withExt {
+			t.Errorf("%q: WithExt(%q) = %q, but should be %q\n", tt.input, tt.ext, s, tt.withExt)
+		}
+		if s := p.WithBase(tt.base).String(); s != tt.withBase {
+			t.Errorf("%q: WithBase(%q) = %q, but should be %q\n", tt.input, tt.base, s, tt.withBase)
+		}
+		if s := p.Join(tt.join...).String(); s != tt.joined {
+			t.Errorf("%q: Join(%q) = %q, but should be %q\n", tt.input, tt.join, s, tt.joined)
+		}
+		if p.String() != tt.input {
+			t.Errorf("%q was changed to %q\n", tt.input, p.String())
+		}
+	}
+
+	t.Log("\tend: TestPathWith")
+}
+
+func TestPathRelations(t *testing.T) {
+	relTests := []struct {
+		path		string
+		base		string
+		rel			string		// "" == error
+	}{
+		{"/a/b/c", "/a", "b/c"},
+		{"/a", "/a/b/c", "../.."},
+		{"/a/b/", "/a/b", "."},
+		{"/x/y", "/a/b", "../../x/y"},
+		{"a/b", ".", "a/b"},
+		{"/", "/a", ".."},
+		{"a", "/a", ""},
+		{"a", "..", ""},
+	}
+	prefixTests := []struct {
+		path		string
+		prefix		string
+		ok			bool
+	}{
+		{"/a/b/c", "/a/b", true},
+		{"/a/b", "/a/b/", true},
+		{"/a/bc", "/a/b", false},
+		{"/a", "/a/b", false},
+		{"/a/b", "/", true},
+		{"/", "/", true},
+		{"a/b", ".", true},
+		{"../a", ".", false},
+		{"../a", "..", true},
+		{"a/b", "/a", false},
+		{"/a/../b", "/b", true},
+	}
+	ancestorTests := []struct {
+		paths		[]string
+		ancestor	string		// "" == none
+	}{
+		{[]string{"/a/b/c", "/a/b/d", "/a/bx"}, "/a"},
+		{[]string{"/a/b/c", "/a/b/c/"}, "/a/b/c"},
+		{[]string{"/a", "/b"}, "/"},
+		{[]string{"a/b", "a/c", "a"}, "a"},
+		{[]string{"a/b", "c"}, "."},
+		{[]string{"../a", "../b"}, ".."},
+		{[]string{"/a", "a"}, ""},
+		{[]string{"x/y"}, "x/y"},
+	}
+
+	t.Log("TestPathRelations()")
+
+	for _, tt := range relTests {
+		rel, err := NewPath(tt.path).Rel(NewPath(tt.base))
+		switch {
+		case tt.rel == "" && err == nil:
+			t.Errorf("Rel(%q, %q) should have failed: %q\n", tt.path, tt.base, rel.String())
+		case tt.rel != "" && (err != nil || rel.String() != tt.rel):
+			t.Errorf("Rel(%q, %q) = %v, %v but should be %q\n", tt.path, tt.base, rel, err, tt.rel)
+		}
+	}
+	for _, tt := range prefixTests {
+		if NewPath(tt.path).HasPrefixPath(NewPath(tt.prefix)) != tt.ok {
+			t.Errorf("HasPrefixPath(%q, %q) should be %v\n", tt.path, tt.prefix, tt.ok)
+		}
+	}
+	for _, tt := range ancestorTests {
+		var others	[]*Path
+		for _, s := range tt.paths[1:] {
+			others = append(others, NewPath(s))
+		}
+		a, ok := NewPath(tt.paths[0]).CommonAncestor(others...)
+		switch {
+		case tt.ancestor == "" && (ok || a != nil):
+			t.Errorf("CommonAncestor(%q) should have none: %v %v\n", tt.paths, a, ok)
+		case tt.ancestor != "" && (!ok || a.String() != tt.ancestor):
+			t.Errorf("CommonAncestor(%q) = %v %v but should be %q\n", tt.paths, a, ok, tt.ancestor)
+		}
+	}
+
+	t.Log("\tend: TestPathRelations")
+}
@@ -0,0 +1,205 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Pipelines
+
+// A Pipeline connects several ExecCmds so that the stdout of each is
+// the stdin of the next just as "cmd1 | cmd2 | cmd3" would in a shell,
+// but without invoking a shell. The stages are connected with operating
+// system pipes so that a stage ending early (such as head) ends the
+// stages writing to it as well.
+
+package util
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+//============================================================================
+//                             Pipeline Result
+//============================================================================
+
+// PipelineResult is the outcome of running a Pipeline once.
+type PipelineResult struct {
+	Stages		[]*ExecResult		// Result of each stage in order
+	Err			error				// Overall error per the pipefail policy
+	pipefail	bool
+}
+
+// ExitCode returns the overall exit code. With pipefail it is the exit
+// code of the last stage that failed or zero if all stages succeeded,
+// otherwise it is the exit code of the last stage.
+func (r *PipelineResult) ExitCode( ) int {
+	if len(r.Stages) == 0 {
+		return -1
+	}
+	if r.pipefail {
+		for i := len(r.Stages) - 1; i >= 0; i-- {
+			if r.Stages[i].ExitCode != 0 {
+				return r.Stages[i].ExitCode
+			}
+		}
+		return 0
+	}
+	return r.Stages[len(r.Stages)-1].ExitCode
+}
+
+// ExitCodes returns the exit code of each stage in order.
+func (r *PipelineResult) ExitCodes( ) []int {
+	codes := make([]int, len(r.Stages))
+	for i, s := range r.Stages {
+		codes[i] = s.ExitCode
+	}
+	return codes
+}
+
+// Stdout returns the output of the last stage.
+func (r *PipelineResult) Stdout( ) []byte {
+	if len(r.Stages) == 0 {
+		return nil
+	}
+	return r.Stages[len(r.Stages)-1].Stdout
+}
+
+//============================================================================
+//                                Pipeline
+//============================================================================
+
+// Pipeline runs several commands connected stdout-to-stdin. Only the
+// last stage's stdout is captured and passed to its line handlers and
+// tees. Every stage's stderr is handled normally.
+type Pipeline struct {
+	cmds		[]*ExecCmd
+	pipefail	bool
+}
+
+// Add appends a stage to the end of the pipeline.
+func (p *Pipeline) Add(c *ExecCmd) *Pipeline {
+	p.cmds = append(p.cmds, c)
+	return p
+}
+
+// Cmds returns the stages of the pipeline.
+func (p *Pipeline) Cmds( ) []*ExecCmd {
+	return p.cmds
+}
+
+// CommandString returns the pipeline as it would be typed in a shell.
+func (p *Pipeline) CommandString( ) string {
+	a := make([]string, len(p.cmds))
+	for i, c := range p.cmds {
+		a[i] = c.CommandString()
+	}
+	return strings.Join(a, " | ")
+}
+
+// Pipefail returns true if any failing stage fails the pipeline.
+func (p *Pipeline) Pipefail( ) bool {
+	return p.pipefail
+}
+
+// SetPipefail selects whether any failing stage fails the pipeline
+// (like "set -o pipefail") or only the last stage counts (the default).
+func (p *Pipeline) SetPipefail(f bool) *Pipeline {
+	p.pipefail = f
+	return p
+}
+
+// Run runs the pipeline returning the overall error.
+func (p *Pipeline) Run( ) error {
+	_, err := p.RunResultContext(context.Background())
+	return err
+}
+
+// RunContext runs the pipeline returning the overall error. The context
+// and each stage's timeout apply as they do in ExecCmd.RunContext.
+func (p *Pipeline) RunContext(ctx context.Context) error {
+	_, err := p.RunResultContext(ctx)
+	return err
+}
+
+// RunResult runs the pipeline returning the results of all stages.
+func (p *Pipeline) RunResult( ) (*PipelineResult, error) {
+	return p.RunResultContext(context.Background())
+}
+
+// RunResultContext runs all of the stages concurrently and waits for
+// them to complete. The result is returned even if an error occurs.
+func (p *Pipeline) RunResultContext(ctx context.Context) (*PipelineResult, error) {
+	var wg		sync.WaitGroup
+
+	r := &PipelineResult{}
+	r.pipefail = p.pipefail
+	n := len(p.cmds)
+	if n == 0 {
+		r.Err = errors.New("Error: Pipeline: there are no stages to run")
+		return r, r.Err
+	}
+
+	// Connect the stages.
+	readers := make([]*os.File, n)
+	writers := make([]*os.File, n)
+	for i := 0; i < n-1; i++ {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			for j := 0; j < i; j++ {
+				readers[j+1].Close()
+				writers[j].Close()
+			}
+			r.Err = err
+			return r, r.Err
+		}
+		readers[i+1] = pr
+		writers[i] = pw
+	}
+
+	// Run them. Each stage's ends of the pipes are closed as soon as
+	// it completes so that its neighbours see EOF or SIGPIPE.
+	r.Stages = make([]*ExecResult, n)
+	for i, c := range p.cmds {
+		wg.Add(1)
+		go func(i int, c *ExecCmd) {
+			defer wg.Done()
+			c.pipeIn = readers[i]
+			c.pipeOut = writers[i]
+			r.Stages[i] = c.execute(ctx, nil)
+			c.pipeIn = nil
+			c.pipeOut = nil
+			if readers[i] != nil {
+				readers[i].Close()
+			}
+			if writers[i] != nil {
+				writers[i].Close()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	if p.pipefail {
+		for i := n - 1; i >= 0; i-- {
+			if r.Stages[i].Err != nil {
+				r.Err = r.Stages[i].Err
+				break
+			}
+		}
+	} else {
+		r.Err = r.Stages[n-1].Err
+	}
+
+	return r, r.Err
+}
+
+//----------------------------------------------------------------------------
+//							Class Functions
+//----------------------------------------------------------------------------
+
+// NewPipeline creates a pipeline from the given stages.
+func NewPipeline(cmds ...*ExecCmd) *Pipeline {
+	p := Pipeline{}
+	p.cmds = append(p.cmds, cmds...)
+	return &p
+}
@@ -0,0 +1,96 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	var err 	error
+	var p		*Pipeline
+	var r		*PipelineResult
+
+	t.Log("TestPipeline()")
+
+	p = NewPipeline(
+			NewExecArgs("printf", "b\\na\\nc\\n"),
+			NewExecArgs("sort"),
+			NewExecArgs("head", "-n", "2"))
+	t.Logf("\t%s\n", p.CommandString())
+	if !strings.HasSuffix(p.CommandString(), " | sort | head -n 2") {
+		t.Errorf("CommandString(): %s\n", p.CommandString())
+	}
+	r, err = p.RunResult()
+	if err != nil {
+		t.Fatalf("RunResult() failed: %s\n", err.Error())
+	}
+	if string(r.Stdout()) != "a\nb\n" {
+		t.Errorf("RunResult() stdout: %q\n", r.Stdout())
+	}
+	codes := r.ExitCodes()
+	if len(codes) != 3 || codes[0] != 0 || codes[1] != 0 || codes[2] != 0 {
+		t.Errorf("RunResult() exit codes: %v\n", codes)
+	}
+
+	// A stage ending early must end the stages feeding it.
+	p = NewPipeline(NewExecArgs("yes"), NewExecArgs("head", "-n", "1"))
+	start := time.Now()
+	r, err = p.RunResult()
+	if err != nil {
+		t.Errorf("yes | head failed: %s\n", err.Error())
+	}
+	if time.Since(start) > 5 * time.Second {
+		t.Errorf("yes | head took %s\n", time.Since(start))
+	}
+	if string(r.Stdout()) != "y\n" {
+		t.Errorf("yes | head stdout: %q\n", r.Stdout())
+	}
+
+	t.Log("\tend: TestPipeline")
+}
+
+func TestPipelinePipefail(t *testing.T) {
+	var err 	error
+	var p		*Pipeline
+	var r		*PipelineResult
+
+	t.Log("TestPipelinePipefail()")
+
+	p = NewPipeline(NewExecArgs("sh", "-c", "echo x; exit 2"), NewExecArgs("cat"))
+	r, err = p.RunResult()
+	if err != nil {
+		t.Errorf("RunResult() without pipefail failed: %s\n", err.Error())
+	}
+	codes := r.ExitCodes()
+	if len(codes) != 2 || codes[0] != 2 || codes[1] != 0 {
+		t.Errorf("RunResult() exit codes: %v\n", codes)
+	}
+	if r.ExitCode() != 0 {
+		t.Errorf("ExitCode() without pipefail: %d\n", r.ExitCode())
+	}
+	if string(r.Stdout()) != "x\n" {
+		t.Errorf("RunResult() stdout: %q\n", r.Stdout())
+	}
+
+	p = NewPipeline(NewExecArgs("sh", "-c", "echo x; exit 2"), NewExecArgs("cat"))
+	p.SetPipefail(true)
+	r, err = p.RunResult()
+	if err == nil {
+		t.Errorf("RunResult() with pipefail should have failed!\n")
+	}
+	if r.ExitCode() != 2 {
+		t.Errorf("ExitCode() with pipefail: %d\n", r.ExitCode())
+	}
+
+	if err = NewPipeline().Run(); err == nil {
+		t.Errorf("Run() of an empty pipeline should have failed!\n")
+	}
+
+	t.Log("\tend: TestPipelinePipefail")
+}
@@ -0,0 +1,120 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Process Groups and Signal Forwarding
+
+// A command run in its own process group can be terminated along with
+// all of the processes that it started. Since such a command no longer
+// receives the signals sent to our process group by the terminal (such
+// as Ctrl-C), the interrupt and terminate signals that we receive can
+// be forwarded to it while it runs.
+
+package util
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+)
+
+//============================================================================
+//                             Process Groups
+//============================================================================
+
+// ForwardSignals returns true if signals received by this process
+// are forwarded to the command while it runs.
+func (c *ExecCmd) ForwardSignals( ) bool {
+	return c.fwdSignals
+}
+
+// SetForwardSignals selects whether the interrupt and terminate signals
+// received by this process are forwarded to the command's process group
+// while it runs. While forwarding, this process does not act on those
+// signals itself. Signals are only forwarded if the command runs in its
+// own process group (see SetProcessGroup) since otherwise the terminal
+// already delivers Ctrl-C to it and forwarding would deliver it twice.
+func (c *ExecCmd) SetForwardSignals(f bool) *ExecCmd {
+	c.fwdSignals = f
+	return c
+}
+
+// ProcessGroup returns true if the command runs in its own process group.
+func (c *ExecCmd) ProcessGroup( ) bool {
+	return c.procGroup
+}
+
+// SetProcessGroup selects whether the command runs in its own process
+// group. If so, terminating the command because of a timeout or a
+// cancelled context signals every process in the group so that no
+// children or grandchildren are left behind. Process groups are only
+// supported on Unix-like systems.
+func (c *ExecCmd) SetProcessGroup(f bool) *ExecCmd {
+	c.procGroup = f
+	return c
+}
+
+// kill forcibly ends the started command (or its process group).
+func (c *ExecCmd) kill(cmd *exec.Cmd) error {
+	return c.signal(cmd, os.Kill)
+}
+
+// signal sends a signal to the started command (or its process group).
+func (c *ExecCmd) signal(cmd *exec.Cmd, sig os.Signal) error {
+	if c.procGroup {
+		return signalProcessGroup(cmd.Process, sig)
+	}
+	return cmd.Process.Signal(sig)
+}
+
+//----------------------------------------------------------------------------
+//                             Signal Forwarder
+//----------------------------------------------------------------------------
+
+// signalForwarder catches signals and passes them to a command. A nil
+// forwarder does nothing so that it need not be checked for.
+type signalForwarder struct {
+	ch			chan os.Signal
+	done		chan bool
+}
+
+// newSignalForwarder starts catching the forwarded signals if the
+// command forwards them and runs in its own process group, otherwise
+// it returns nil. Signals caught before Start is called are delivered
+// once it is.
+func (c *ExecCmd) newSignalForwarder( ) *signalForwarder {
+	if !c.fwdSignals || !c.procGroup {
+		return nil
+	}
+	f := signalForwarder{}
+	f.ch = make(chan os.Signal, 4)
+	f.done = make(chan bool)
+	signal.Notify(f.ch, forwardedSignals...)
+	return &f
+}
+
+// Start forwards the caught signals to the started command until
+// Stop is called.
+func (f *signalForwarder) Start(c *ExecCmd, cmd *exec.Cmd) {
+	if f == nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case sig := <-f.ch:
+				c.signal(cmd, sig)
+			case <-f.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops catching and forwarding signals.
+func (f *signalForwarder) Stop( ) {
+	if f == nil {
+		return
+	}
+	signal.Stop(f.ch)
+	close(f.done)
+}
@@ -0,0 +1,116 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processGone returns true if the process no longer exists or is
+// a zombie waiting to be reaped.
+func processGone(pid int) bool {
+	text, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	// The state follows the parenthesized command name.
+	fields := strings.Fields(string(text[strings.LastIndex(string(text), ")")+1:]))
+	return len(fields) > 0 && (fields[0] == "Z" || fields[0] == "X")
+}
+
+// waitProcessGone waits a little while for the process to disappear.
+func waitProcessGone(pid int) bool {
+	for i := 0; i < 50; i++ {
+		if processGone(pid) {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+// startGrandchild runs a shell which starts a grandchild and reports
+// its pid. The context is cancelled once the pid has been reported.
+func startGrandchild(t *testing.T, group bool) int {
+	var pid		int
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := NewExecArgs("sh", "-c", "sleep 30 >/dev/null 2>&1 & echo $!; wait")
+	cmd.SetProcessGroup(group).SetGracePeriod(time.Second)
+	cmd.OnStdoutLine(func(s string) {
+		pid, _ = strconv.Atoi(s)
+		cancel()
+	})
+	start := time.Now()
+	err := cmd.RunContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RunContext() should have been cancelled: %v\n", err)
+	}
+	if time.Since(start) > 10 * time.Second {
+		t.Errorf("RunContext() took %s to cancel\n", time.Since(start))
+	}
+	if pid == 0 {
+		t.Fatalf("grandchild pid was not reported\n")
+	}
+	return pid
+}
+
+func TestProcessGroupKill(t *testing.T) {
+
+	t.Log("TestProcessGroupKill()")
+
+	pid := startGrandchild(t, true)
+	if !waitProcessGone(pid) {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Errorf("grandchild %d survived the process group being cancelled\n", pid)
+	}
+
+	// Without a process group the grandchild is orphaned.
+	pid = startGrandchild(t, false)
+	if processGone(pid) {
+		t.Errorf("grandchild %d should have been orphaned\n", pid)
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+
+	t.Log("\tend: TestProcessGroupKill")
+}
+
+func TestForwardSignals(t *testing.T) {
+
+	t.Log("TestForwardSignals()")
+
+	cmd := NewExecArgs("sh", "-c",
+				"trap 'echo caught; exit 3' INT; echo ready; while :; do sleep 0.1; done")
+	cmd.SetProcessGroup(true).SetForwardSignals(true).SetTimeout(10 * time.Second)
+	cmd.OnStdoutLine(func(s string) {
+		if s == "ready" {
+			syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+		}
+	})
+	r, err := cmd.RunResult()
+	if err == nil {
+		t.Errorf("RunResult() should have failed\n")
+	}
+	if r.ExitCode != 3 || !strings.Contains(string(r.Stdout), "caught") {
+		t.Errorf("signal was not forwarded: %d %q %v\n", r.ExitCode, r.Stdout, err)
+	}
+
+	// Without its own process group, the command already gets Ctrl-C.
+	if f := NewExecArgs("true").SetForwardSignals(true).newSignalForwarder(); f != nil {
+		f.Stop()
+		t.Errorf("signals should not be forwarded outside of a process group\n")
+	}
+
+	t.Log("\tend: TestForwardSignals")
+}
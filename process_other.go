@@ -0,0 +1,34 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+//go:build windows || plan9
+// +build windows plan9
+
+// Process Groups for Other Systems
+
+// Process groups are not supported so signals only go to the command.
+
+package util
+
+import (
+	"os"
+	"os/exec"
+)
+
+// forwardedSignals are the signals passed on by SetForwardSignals.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// terminateSignal asks a command to end. Since it can not be delivered
+// on these systems, the command is killed instead.
+var terminateSignal os.Signal = os.Interrupt
+
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
+
+func exitSignal(ps *os.ProcessState) string {
+	return ""
+}
@@ -0,0 +1,206 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Line Quoting
+
+// These functions quote arguments so that a command line can be given
+// to a shell (or parsed by ParseCommandLine) and produce the original
+// arguments. POSIX quoting is used by ExecCmd.CommandString. Windows
+// quoting follows the rules of CommandLineToArgvW and is provided as
+// pure string functions so that scripts for Windows can be generated
+// on any system.
+
+package util
+
+import (
+	"strings"
+)
+
+//============================================================================
+//                             POSIX Quoting
+//============================================================================
+
+// posixSafe contains the characters which never need quoting in a
+// POSIX shell.
+const posixSafe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-"
+
+// QuoteArgPosix returns the argument unchanged if it only contains
+// characters that are safe in a POSIX shell, otherwise it is returned
+// in single quotes with any embedded single quotes escaped as '\''.
+// An empty argument is returned as ''.
+func QuoteArgPosix(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !strings.ContainsRune(posixSafe, r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+//============================================================================
+//                             Windows Quoting
+//============================================================================
+
+// isWindowsSpace returns true for the characters that separate
+// arguments in a Windows command line.
+func isWindowsSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// QuoteArgWindows returns the argument quoted so that CommandLineToArgvW
+// (and ParseCommandLineWindows) returns it unchanged. Backslashes are
+// only doubled when they precede a double quote. Note that characters
+// special to cmd.exe such as & and | are not escaped.
+func QuoteArgWindows(s string) string {
+	var b		strings.Builder
+	var nb		int
+
+	if s != "" && !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			nb++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, 2*nb+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, nb))
+		}
+		b.WriteRune(r)
+		nb = 0
+	}
+	b.WriteString(strings.Repeat(`\`, 2*nb))
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// quoteProgramWindows quotes the program name which CommandLineToArgvW
+// parses without any backslash processing.
+func quoteProgramWindows(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t") {
+		return s
+	}
+	return `"` + s + `"`
+}
+
+// CommandStringWindows returns the command line quoted for Windows.
+func (c *ExecCmd) CommandStringWindows( ) string {
+	if c.cmd == nil {
+		return ""
+	}
+	n := len(c.cmd.Args)
+	a := make([]string, n, n)
+	for i := 0; i < n; i++ {
+		if i == 0 {
+			a[i] = quoteProgramWindows(c.cmd.Args[i])
+		} else {
+			a[i] = QuoteArgWindows(c.cmd.Args[i])
+		}
+	}
+	return strings.Join(a, " ")
+}
+
+/*   ParseCommandLineWindows breaks up a command line the same way
+     that CommandLineToArgvW does:
+       - Arguments are separated by spaces and tabs outside of quotes.
+       - The program name (first argument) ends at the first space or
+         tab unless it begins with a double quote, in which case it
+         ends at the next double quote. Backslashes are not special.
+       - 2n backslashes followed by a double quote produce n
+         backslashes and the quote begins or ends a quoted section.
+       - 2n+1 backslashes followed by a double quote produce n
+         backslashes and a literal double quote.
+       - Backslashes not followed by a double quote are literal.
+       - Two double quotes within a quoted section produce a literal
+         double quote.
+     Like CommandLineToArgvW, an unterminated quote simply runs to the
+     end of the command line.
+ */
+func ParseCommandLineWindows(cmd string) []string {
+	var args	[]string
+	var word	[]rune
+	var i		int
+
+	r := []rune(cmd)
+	n := len(r)
+
+	// Scan off the program name.
+	for (i < n) && isWindowsSpace(r[i]) {
+		i++
+	}
+	if i >= n {
+		return args
+	}
+	if r[i] == '"' {
+		for i++; (i < n) && (r[i] != '"'); i++ {
+			word = append(word, r[i])
+		}
+		i++
+	} else {
+		for ; (i < n) && !isWindowsSpace(r[i]); i++ {
+			word = append(word, r[i])
+		}
+	}
+	args = append(args, string(word))
+
+	// Scan off the arguments.
+	for {
+		for (i < n) && isWindowsSpace(r[i]) {
+			i++
+		}
+		if i >= n {
+			break
+		}
+		word = word[:0]
+		inQuote := false
+		for i < n {
+			if !inQuote && isWindowsSpace(r[i]) {
+				break
+			}
+			switch r[i] {
+			case '\\':
+				nb := 0
+				for (i < n) && (r[i] == '\\') {
+					nb++
+					i++
+				}
+				if (i < n) && (r[i] == '"') {
+					word = append(word, []rune(strings.Repeat(`\`, nb/2))...)
+					if nb%2 == 1 {
+						word = append(word, '"')
+						i++
+					}
+				} else {
+					word = append(word, []rune(strings.Repeat(`\`, nb))...)
+				}
+			case '"':
+				if inQuote && (i+1 < n) && (r[i+1] == '"') {
+					word = append(word, '"')
+					i += 2
+				} else {
+					inQuote = !inQuote
+					i++
+				}
+			default:
+				word = append(word, r[i])
+				i++
+			}
+		}
+		args = append(args, string(word))
+	}
+
+	return args
+}
@@ -0,0 +1,141 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"testing"
+)
+
+// quoteTestArgs are awkward arguments which must survive quoting.
+var quoteTestArgs = []string{
+	"plain",
+	"",
+	"two words",
+	"tab\there",
+	"new\nline",
+	"$HOME",
+	"`date`",
+	"*.go",
+	"a;b",
+	"it's",
+	`say "hi"`,
+	`back\slash`,
+	`trailing\`,
+	`\\"`,
+	"'",
+	`"`,
+	"ünïcödé",
+	"a&b|c<d>e^f",
+	"--flag=x y",
+}
+
+func equalArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQuoteArgPosix(t *testing.T) {
+
+	t.Log("TestQuoteArgPosix()")
+
+	if QuoteArgPosix("abc/d.go") != "abc/d.go" {
+		t.Errorf("QuoteArgPosix(abc/d.go) = %s\n", QuoteArgPosix("abc/d.go"))
+	}
+	if QuoteArgPosix("") != "''" {
+		t.Errorf("QuoteArgPosix(\"\") = %s\n", QuoteArgPosix(""))
+	}
+	if QuoteArgPosix("it's") != `'it'\''s'` {
+		t.Errorf("QuoteArgPosix(it's) = %s\n", QuoteArgPosix("it's"))
+	}
+
+	// Round trip through ParseCommandLine.
+	args := append([]string{"prog"}, quoteTestArgs...)
+	cmd := NewExecArgs(args[0], args[1:]...)
+	str := cmd.CommandString()
+	t.Logf("\t%s\n", str)
+	parsed, err := ParseCommandLine(str)
+	if err != nil {
+		t.Fatalf("ParseCommandLine(%q) failed: %s\n", str, err.Error())
+	}
+	if !equalArgs(parsed, args) {
+		t.Errorf("ParseCommandLine(CommandString()) = %q, but should be %q\n", parsed, args)
+	}
+
+	// Round trip through a real shell.
+	script := "printf '[%s]'"
+	expected := ""
+	for _, a := range quoteTestArgs {
+		script += " " + QuoteArgPosix(a)
+		expected += "[" + a + "]"
+	}
+	r, err := NewExecArgs("sh", "-c", script).RunResult()
+	if err != nil {
+		t.Fatalf("sh -c %q failed: %s\n", script, err.Error())
+	}
+	if string(r.Stdout) != expected {
+		t.Errorf("sh -c %q = %q, but should be %q\n", script, r.Stdout, expected)
+	}
+
+	t.Log("\tend: TestQuoteArgPosix")
+}
+
+func TestQuoteArgWindows(t *testing.T) {
+
+	t.Log("TestQuoteArgWindows()")
+
+	args := append([]string{`C:\Program Files\prog.exe`}, quoteTestArgs...)
+	cmd := NewExecArgs(args[0], args[1:]...)
+	str := cmd.CommandStringWindows()
+	t.Logf("\t%s\n", str)
+	parsed := ParseCommandLineWindows(str)
+	if !equalArgs(parsed, args) {
+		t.Errorf("ParseCommandLineWindows(CommandStringWindows()) = %q, but should be %q\n", parsed, args)
+	}
+
+	if QuoteArgWindows(`a\b`) != `a\b` {
+		t.Errorf("QuoteArgWindows(a\\b) = %s\n", QuoteArgWindows(`a\b`))
+	}
+	if QuoteArgWindows(`a b\`) != `"a b\\"` {
+		t.Errorf("QuoteArgWindows(a b\\) = %s\n", QuoteArgWindows(`a b\`))
+	}
+	if QuoteArgWindows(`a\"b`) != `"a\\\"b"` {
+		t.Errorf("QuoteArgWindows(a\\\"b) = %s\n", QuoteArgWindows(`a\"b`))
+	}
+
+	t.Log("\tend: TestQuoteArgWindows")
+}
+
+func TestParseCommandLineWindows(t *testing.T) {
+	var test 	func (string,...string)
+
+	t.Log("TestParseCommandLineWindows()")
+	test = func(cmd string, expected ...string) {
+		args := ParseCommandLineWindows(cmd)
+		if !equalArgs(args, expected) {
+			t.Errorf("ParseCommandLineWindows(%q) = %q, but should be %q!\n", cmd, args, expected)
+		}
+	}
+
+	// Examples from the CommandLineToArgvW documentation.
+	test(`p "abc" d e`, "p", "abc", "d", "e")
+	test(`p a\\\b d"e f"g h`, "p", `a\\\b`, "de fg", "h")
+	test(`p a\\\"b c d`, "p", `a\"b`, "c", "d")
+	test(`p a\\\\"b c" d e`, "p", `a\\b c`, "d", "e")
+
+	test(`"C:\dir name\p.exe" x`, `C:\dir name\p.exe`, "x")
+	test(`C:\bin\p.exe "" "a""b" "open`, `C:\bin\p.exe`, "", `a"b`, "open")
+	test("  p\t a  ", "p", "a")
+	test("")
+
+	t.Log("\tend: TestParseCommandLineWindows")
+}
@@ -0,0 +1,83 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Resource Limits and Usage
+
+// ResourceLimits stops runaway commands by limiting what they may use.
+// ResourceUsage reports what a command used once it has completed. The
+// limits are only supported on Linux. Since Go provides no way to run
+// code between fork and exec, the command is run by prlimit(1) which
+// applies the limits to itself and then executes the command. If
+// prlimit(1) is not installed, the shell's ulimit is used instead. It
+// works in units of 1024 bytes for the address space and 512 bytes for
+// the file size so those limits are rounded down to a whole unit. A
+// limit that the shell can not set stops the command from being run and
+// is reported on stderr. Any processes that the command starts inherit
+// the limits. Usage is reported on all systems, but only the CPU times
+// are available on systems other than Linux.
+
+package util
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+//============================================================================
+//                             Resource Limits
+//============================================================================
+
+// ResourceLimits are the limits to be applied to a command. Zero means
+// that the limit is not changed from the one inherited from us.
+type ResourceLimits struct {
+	CPUSeconds		uint64		`json:"cpu_seconds,omitempty"`		// RLIMIT_CPU
+	AddressSpace	uint64		`json:"address_space,omitempty"`	// RLIMIT_AS in bytes
+	OpenFiles		uint64		`json:"open_files,omitempty"`		// RLIMIT_NOFILE
+	FileSize		uint64		`json:"file_size,omitempty"`		// RLIMIT_FSIZE in bytes
+}
+
+// ResourceLimits returns the limits applied to the command or nil.
+func (c *ExecCmd) ResourceLimits( ) *ResourceLimits {
+	return c.limits
+}
+
+// SetResourceLimits sets the limits applied to the command. nil
+// removes them.
+func (c *ExecCmd) SetResourceLimits(l *ResourceLimits) *ExecCmd {
+	c.limits = l
+	return c
+}
+
+// startProcess starts the command's process applying its limits
+// before it begins to run.
+func (c *ExecCmd) startProcess(cmd *exec.Cmd) error {
+	if (c.limits == nil) || (*c.limits == ResourceLimits{}) {
+		return cmd.Start()
+	}
+	return startWithLimits(cmd, c.limits)
+}
+
+//============================================================================
+//                             Resource Usage
+//============================================================================
+
+// ResourceUsage is what a completed command used.
+type ResourceUsage struct {
+	UserTime				time.Duration	`json:"user_time"`
+	SystemTime				time.Duration	`json:"system_time"`
+	MaxRSS					int64			`json:"max_rss,omitempty"`		// Bytes
+	MinorFaults				int64			`json:"minor_faults,omitempty"`
+	MajorFaults				int64			`json:"major_faults,omitempty"`
+	VoluntarySwitches		int64			`json:"voluntary_switches,omitempty"`
+	InvoluntarySwitches		int64			`json:"involuntary_switches,omitempty"`
+}
+
+// newResourceUsage returns what the completed process used.
+func newResourceUsage(ps *os.ProcessState) *ResourceUsage {
+	u := &ResourceUsage{}
+	u.UserTime = ps.UserTime()
+	u.SystemTime = ps.SystemTime()
+	addSystemUsage(u, ps)
+	return u
+}
@@ -0,0 +1,99 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Resource Limits and Usage for Linux
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// limitsHelper is the program that applies the limits to itself before
+// executing the command.
+var limitsHelper = "prlimit"
+
+// limitsShell is the shell used to apply the limits if limitsHelper can
+// not be found.
+var limitsShell = "/bin/sh"
+
+// resourceLimit is one of the limits to be applied.
+type resourceLimit struct {
+	option		string				// prlimit(1) option
+	ulimit		string				// ulimit option
+	unit		uint64				// Bytes per ulimit unit
+	value		uint64
+}
+
+// resourceLimitList returns the limits in the order that they are
+// applied.
+func resourceLimitList(l *ResourceLimits) []resourceLimit {
+	return []resourceLimit{
+		{"--cpu", "-t", 1, l.CPUSeconds},
+		{"--as", "-v", 1024, l.AddressSpace},
+		{"--nofile", "-n", 1, l.OpenFiles},
+		{"--fsize", "-f", 512, l.FileSize},
+	}
+}
+
+// startWithLimits starts the command run by the limits helper or, if it
+// can not be found, the shell. Either applies the limits to itself and
+// then executes the command in the same process. The command is looked
+// up first so that it not being found is reported as usual rather than
+// by the helper.
+func startWithLimits(cmd *exec.Cmd, l *ResourceLimits) error {
+	var args	[]string
+
+	if filepath.IsAbs(cmd.Path) || !strings.Contains(cmd.Path, string(filepath.Separator)) {
+		if _, err := exec.LookPath(cmd.Path); err != nil {
+			return err
+		}
+	}
+
+	if helper, err := exec.LookPath(limitsHelper); err == nil {
+		args = []string{helper}
+		for _, lim := range resourceLimitList(l) {
+			if lim.value != 0 {
+				args = append(args, fmt.Sprintf("%s=%d", lim.option, lim.value))
+			}
+		}
+		args = append(args, "--")
+	} else {
+		// Each ulimit sets both the soft and hard limit. A limit that is
+		// not a whole number of units is rounded down.
+		var script	[]string
+		for _, lim := range resourceLimitList(l) {
+			if lim.value != 0 {
+				script = append(script, fmt.Sprintf("ulimit %s %d", lim.ulimit, lim.value/lim.unit))
+			}
+		}
+		script = append(script, `exec "$@"`)
+		args = []string{limitsShell, "-c", strings.Join(script, " && "), "sh"}
+	}
+
+	args = append(args, cmd.Path)
+	if len(cmd.Args) > 1 {
+		args = append(args, cmd.Args[1:]...)
+	}
+	cmd.Path = args[0]
+	cmd.Args = args
+	return cmd.Start()
+}
+
+// addSystemUsage adds the Linux specific usage.
+func addSystemUsage(u *ResourceUsage, ps *os.ProcessState) {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return
+	}
+	u.MaxRSS = int64(ru.Maxrss) * 1024				// Linux reports kilobytes.
+	u.MinorFaults = int64(ru.Minflt)
+	u.MajorFaults = int64(ru.Majflt)
+	u.VoluntarySwitches = int64(ru.Nvcsw)
+	u.InvoluntarySwitches = int64(ru.Nivcsw)
+}
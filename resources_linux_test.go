@@ -0,0 +1,102 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// checkResourceLimits checks that the limits are applied however that
+// is done.
+func checkResourceLimits(t *testing.T, how string) {
+	// A runaway loop is stopped by the CPU limit.
+	cmd := NewExecArgs("sh", "-c", "while :; do :; done")
+	cmd.SetResourceLimits(&ResourceLimits{CPUSeconds: 1}).SetTimeout(20 * time.Second)
+	r, err := cmd.RunResult()
+	if err == nil {
+		t.Fatalf("%s: CPU limit did not stop the command\n", how)
+	}
+	t.Logf("\t%s: %s\n", how, err.Error())
+	if r.Signal == "" || r.Signal == "terminated" {
+		t.Errorf("%s: CPU limit signal: %q\n", how, r.Signal)
+	}
+	if r.Usage == nil || r.Usage.UserTime + r.Usage.SystemTime < 900 * time.Millisecond {
+		t.Errorf("%s: CPU usage: %+v\n", how, r.Usage)
+	}
+
+	// Files can not grow beyond the file size limit.
+	file := NewTempDir().Append("resources_test.dat")
+	defer file.DeleteFile()
+	cmd = NewExecArgs("sh", "-c", `head -c 2000000 /dev/zero > "$1"`, "sh", file.Absolute())
+	cmd.SetResourceLimits(&ResourceLimits{FileSize: 1 << 20})
+	if err = cmd.Run(); err == nil {
+		t.Errorf("%s: file size limit did not stop the command\n", how)
+	}
+	if file.Size() > 1 << 20 {
+		t.Errorf("%s: file size limit was exceeded: %d\n", how, file.Size())
+	}
+
+	// The limits are inherited by the command's children.
+	cmd = NewExecArgs("sh", "-c", "ulimit -n")
+	out, err := cmd.SetResourceLimits(&ResourceLimits{OpenFiles: 64}).RunWithOutput()
+	if err != nil || out != "64" {
+		t.Errorf("%s: open files limit: %q %v\n", how, out, err)
+	}
+
+	// A command that is not found is reported as usual.
+	err = NewExecArgs("xyzzy_not_there").SetResourceLimits(&ResourceLimits{OpenFiles: 64}).Run()
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("%s: xyzzy_not_there: %v\n", how, err)
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+
+	t.Log("TestResourceLimits()")
+
+	if _, err := exec.LookPath(limitsHelper); err == nil {
+		checkResourceLimits(t, limitsHelper)
+	} else {
+		t.Logf("\t%s was not found\n", limitsHelper)
+	}
+
+	// Without the helper, the shell applies the limits.
+	save := limitsHelper
+	limitsHelper = "xyzzy_no_limits_helper"
+	defer func() { limitsHelper = save }()
+	checkResourceLimits(t, "ulimit")
+
+	t.Log("\tend: TestResourceLimits")
+}
+
+func TestResourceUsage(t *testing.T) {
+
+	t.Log("TestResourceUsage()")
+
+	r, err := NewExecArgs("sh", "-c", "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done").RunResult()
+	if err != nil {
+		t.Fatalf("RunResult() failed: %s\n", err.Error())
+	}
+	u := r.Usage
+	if u == nil {
+		t.Fatalf("RunResult() did not report usage\n")
+	}
+	t.Logf("\tusage: %+v\n", *u)
+	if u.MaxRSS <= 0 || u.UserTime + u.SystemTime <= 0 || u.VoluntarySwitches + u.InvoluntarySwitches < 0 {
+		t.Errorf("usage: %+v\n", *u)
+	}
+
+	// Commands that did not run have no usage.
+	r, _ = NewExecArgs("./xyzzy_not_there").RunResult()
+	if r.Usage != nil {
+		t.Errorf("usage of a command that did not run: %+v\n", *r.Usage)
+	}
+
+	t.Log("\tend: TestResourceUsage")
+}
@@ -0,0 +1,22 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+//go:build !linux
+// +build !linux
+
+// Command Resource Limits and Usage for Other Systems
+
+package util
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func startWithLimits(cmd *exec.Cmd, l *ResourceLimits) error {
+	return errors.New("Error: resource limits are only supported on Linux")
+}
+
+func addSystemUsage(u *ResourceUsage, ps *os.ProcessState) {
+}
@@ -0,0 +1,191 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Retry Policy
+
+// A RetryPolicy allows a flaky command to be run again when it fails.
+// The delay between attempts grows exponentially with some random
+// jitter so that several processes retrying at once spread out. The
+// policy's RetryIf function decides which failures are worth retrying.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+//============================================================================
+//                             Retry Policy
+//============================================================================
+
+// RetryPolicy controls how a failing ExecCmd is retried.
+type RetryPolicy struct {
+	MaxAttempts		int						// Total attempts, <= 1 == no retries
+	InitialDelay	time.Duration			// Delay before the first retry
+	MaxDelay		time.Duration			// Maximum delay, 0 == none
+	Multiplier		float64					// Delay growth, <= 1 == 2
+	Jitter			float64					// Random fraction (0-1) of the delay
+	RetryIf			func(*ExecResult) bool	// nil == retry any failure
+}
+
+// Delay returns the time to wait before the given retry (1-relative)
+// including jitter. Without a MaxDelay, the delay stops growing at the
+// largest Duration rather than overflowing.
+func (p *RetryPolicy) Delay(retry int) time.Duration {
+	mult := p.Multiplier
+	if mult <= 1 {
+		mult = 2
+	}
+	max := float64(math.MaxInt64)
+	if p.MaxDelay > 0 {
+		max = float64(p.MaxDelay)
+	}
+	d := float64(p.InitialDelay)
+	for i := 1; (i < retry) && (d < max); i++ {
+		d *= mult
+	}
+	if d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		d += (rand.Float64()*2 - 1) * p.Jitter * d
+	}
+	if d < 0 {
+		d = 0
+	}
+	// float64(math.MaxInt64) rounds up so it does not fit a Duration.
+	if d >= float64(math.MaxInt64) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// ShouldRetry returns true if the failed result may be retried.
+func (p *RetryPolicy) ShouldRetry(r *ExecResult) bool {
+	if (r.Err == nil) && (r.ExitCode == 0) {
+		return false
+	}
+	if p.RetryIf == nil {
+		return true
+	}
+	return p.RetryIf(r)
+}
+
+// RetryOnExitCodes returns a RetryIf function which retries only
+// the given exit codes.
+func RetryOnExitCodes(codes ...int) func(*ExecResult) bool {
+	return func(r *ExecResult) bool {
+		for _, code := range codes {
+			if r.ExitCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RetryOnStderr returns a RetryIf function which retries only if
+// stderr matches the given regular expression.
+func RetryOnStderr(re *regexp.Regexp) func(*ExecResult) bool {
+	return func(r *ExecResult) bool {
+		return re.Match(r.Stderr)
+	}
+}
+
+//----------------------------------------------------------------------------
+//							Command Methods
+//----------------------------------------------------------------------------
+
+// Attempts returns the result of every attempt made by the last run
+// of the command in order. The last one is also returned by Result().
+func (c *ExecCmd) Attempts( ) []*ExecResult {
+	return append([]*ExecResult{}, c.attempts...)
+}
+
+// RetryPolicy returns the command's retry policy or nil if there is none.
+func (c *ExecCmd) RetryPolicy( ) *RetryPolicy {
+	return c.retry
+}
+
+// SetRetryPolicy sets the command's retry policy. nil means that the
+// command is only attempted once.
+func (c *ExecCmd) SetRetryPolicy(p *RetryPolicy) *ExecCmd {
+	c.retry = p
+	return c
+}
+
+// executeWithRetry executes the command until it succeeds or the retry
+// policy gives up. If combined is not nil, it only contains the output
+// of the last attempt. If the context is done before a retry, the retry
+// is not made and its result, the last of the attempts, has the
+// context's error.
+func (c *ExecCmd) executeWithRetry(ctx context.Context, combined *bytes.Buffer) *ExecResult {
+	var r		*ExecResult
+
+	c.attempts = nil
+	for attempt := 1; ; attempt++ {
+		var w	io.Writer
+		if combined != nil {
+			combined.Reset()
+			w = combined
+		}
+		r = c.execute(ctx, w)
+		c.attempts = append(c.attempts, r)
+		p := c.retry
+		if (p == nil) || (attempt >= p.MaxAttempts) || !p.ShouldRetry(r) {
+			break
+		}
+		timer := time.NewTimer(p.Delay(attempt))
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+		if ctx.Err() != nil {
+			r = c.cancelledAttempt(ctx)
+			c.attempts = append(c.attempts, r)
+			break
+		}
+	}
+
+	return r
+}
+
+// cancelledAttempt returns the result of a retry that was not made
+// because the context was done.
+func (c *ExecCmd) cancelledAttempt(ctx context.Context) *ExecResult {
+	err := ctx.Err()
+	if err == context.DeadlineExceeded {
+		err = ErrTimeout
+	}
+	r := &ExecResult{}
+	r.Args = append([]string{}, c.cmd.Args...)
+	r.ExitCode = -1
+	r.StartTime = time.Now()
+	r.EndTime = r.StartTime
+	r.Err = c.newExecError(r, err)
+	c.result = r
+	return r
+}
+
+//----------------------------------------------------------------------------
+//							Class Functions
+//----------------------------------------------------------------------------
+
+// NewRetryPolicy returns a policy which retries any failure up to the
+// given total number of attempts starting with the given delay and
+// doubling it each time with 10% jitter.
+func NewRetryPolicy(attempts int, delay time.Duration) *RetryPolicy {
+	p := RetryPolicy{}
+	p.MaxAttempts = attempts
+	p.InitialDelay = delay
+	p.Multiplier = 2
+	p.Jitter = 0.1
+	return &p
+}
@@ -0,0 +1,140 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// counterScript fails until it has been run the given number of times
+// using a counter file.
+func counterScript(file *Path, succeedOn string) *ExecCmd {
+	script := `n=$(cat "$1" 2>/dev/null || echo 0); n=$((n+1)); echo $n > "$1"; ` +
+				`echo "attempt $n"; if [ $n -lt ` + succeedOn + ` ]; then echo "locked" >&2; exit 75; fi`
+	return NewExecArgs("sh", "-c", script, "sh", file.Absolute())
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var err 	error
+
+	t.Log("TestRetryPolicy()")
+
+	file := NewTempDir().Append("retry_test_counter.txt")
+	file.DeleteFile()
+	defer file.DeleteFile()
+
+	cmd := counterScript(file, "3")
+	cmd.SetRetryPolicy(NewRetryPolicy(5, 10 * time.Millisecond))
+	out, err := cmd.RunWithOutput()
+	if err != nil {
+		t.Fatalf("RunWithOutput() with retries failed: %s\n", err.Error())
+	}
+	if out != "attempt 3" {
+		t.Errorf("RunWithOutput() output: %q\n", out)
+	}
+	attempts := cmd.Attempts()
+	if len(attempts) != 3 {
+		t.Fatalf("Attempts(): %d\n", len(attempts))
+	}
+	for i, a := range attempts[:2] {
+		if a.ExitCode != 75 || a.StderrString() != "locked" {
+			t.Errorf("attempt %d: %d %q\n", i+1, a.ExitCode, a.Stderr)
+		}
+	}
+	if cmd.Result() != attempts[2] || !attempts[2].Success() {
+		t.Errorf("Result() is not the last attempt\n")
+	}
+
+	// Too few attempts.
+	file.DeleteFile()
+	cmd = counterScript(file, "3")
+	cmd.SetRetryPolicy(NewRetryPolicy(2, time.Millisecond))
+	if err = cmd.Run(); err == nil {
+		t.Errorf("Run() with 2 attempts should have failed!\n")
+	}
+	if len(cmd.Attempts()) != 2 {
+		t.Errorf("Attempts(): %d\n", len(cmd.Attempts()))
+	}
+
+	// Only retry some failures.
+	file.DeleteFile()
+	p := NewRetryPolicy(5, time.Millisecond)
+	p.RetryIf = RetryOnExitCodes(1, 2)
+	cmd = counterScript(file, "3").SetRetryPolicy(p)
+	if err = cmd.Run(); err == nil || len(cmd.Attempts()) != 1 {
+		t.Errorf("RetryOnExitCodes(1, 2) retried exit code 75: %d\n", len(cmd.Attempts()))
+	}
+	file.DeleteFile()
+	p.RetryIf = RetryOnStderr(regexp.MustCompile("lock"))
+	cmd = counterScript(file, "3").SetRetryPolicy(p)
+	if err = cmd.Run(); err != nil || len(cmd.Attempts()) != 3 {
+		t.Errorf("RetryOnStderr(lock): %v %d\n", err, len(cmd.Attempts()))
+	}
+
+	t.Log("\tend: TestRetryPolicy")
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+
+	t.Log("TestRetryPolicyDelay()")
+
+	p := NewRetryPolicy(10, 100 * time.Millisecond)
+	p.Jitter = 0
+	p.MaxDelay = time.Second
+	expected := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, e := range expected {
+		if d := p.Delay(i+1); d != e * time.Millisecond {
+			t.Errorf("Delay(%d) = %s, but should be %s\n", i+1, d, e * time.Millisecond)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.Delay(1); d < 50 * time.Millisecond || d > 150 * time.Millisecond {
+			t.Errorf("Delay(1) with jitter = %s\n", d)
+		}
+	}
+
+	// Without a maximum, the delay stops growing instead of overflowing.
+	p.MaxDelay = 0
+	for _, retry := range []int{70, 1000} {
+		if d := p.Delay(retry); d < 1000 * time.Hour {
+			t.Errorf("Delay(%d) without a maximum = %s\n", retry, d)
+		}
+	}
+
+	// Cancelling the context stops the retries.
+	ctx, cancel := context.WithTimeout(context.Background(), 200 * time.Millisecond)
+	defer cancel()
+	cmd := NewExecArgs("false").SetRetryPolicy(NewRetryPolicy(100, 50 * time.Millisecond))
+	start := time.Now()
+	if err := cmd.RunContext(ctx); err == nil {
+		t.Errorf("RunContext(false) should have failed!\n")
+	}
+	if time.Since(start) > 2 * time.Second {
+		t.Errorf("RunContext(false) retried for %s\n", time.Since(start))
+	}
+
+	// Without a delay, a cancelled context still stops the retries and
+	// the retry not made is the last attempt.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	cmd = NewExecArgs("false").SetRetryPolicy(NewRetryPolicy(100, 0))
+	err := cmd.RunContext(ctx)
+	attempts := cmd.Attempts()
+	if !errors.Is(err, context.Canceled) || len(attempts) != 2 {
+		t.Fatalf("RunContext(false) with a cancelled context: %v %d\n", err, len(attempts))
+	}
+	if last := attempts[1]; last != cmd.Result() || last.ExitCode != -1 || !errors.Is(last.Err, context.Canceled) {
+		t.Errorf("cancelled attempt: %#v\n", last)
+	}
+
+	t.Log("\tend: TestRetryPolicyDelay")
+}
@@ -0,0 +1,116 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Parallel Command Execution
+
+// RunAll runs many independent commands using a WorkQueue to limit how
+// many run at once. The results are returned in the same order as the
+// commands. Either all of the commands are run regardless of failures
+// or, if failing fast, the first failure terminates the commands still
+// running and prevents the rest from starting.
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//============================================================================
+//                             Run All Commands
+//============================================================================
+
+// ErrNotRun is the error of a command that was not started because
+// an earlier command failed while failing fast.
+var ErrNotRun = errors.New("command not run: an earlier command failed")
+
+// RunAllError summarizes the commands that failed in a RunAll.
+type RunAllError struct {
+	Cmds		[]*ExecCmd			// All of the commands
+	Results		[]*ExecResult		// All of the results
+	Failed		[]int				// Indices of the commands that failed
+	NotRun		[]int				// Indices of the commands not started
+}
+
+// Error lists each failed command on its own line.
+func (e *RunAllError) Error( ) string {
+	var b		strings.Builder
+
+	fmt.Fprintf(&b, "Error: %d of %d commands failed", len(e.Failed), len(e.Cmds))
+	if len(e.NotRun) > 0 {
+		fmt.Fprintf(&b, ", %d not run", len(e.NotRun))
+	}
+	b.WriteString(":")
+	for _, i := range e.Failed {
+		var ee	*ExecError
+		if errors.As(e.Results[i].Err, &ee) {
+			fmt.Fprintf(&b, "\n\t[%d] %s", i, ee.Error())
+		} else {
+			fmt.Fprintf(&b, "\n\t[%d] %s: %s", i, e.Cmds[i].CommandString(), ErrorString(e.Results[i].Err))
+		}
+	}
+	return b.String()
+}
+
+// RunAll runs all of the commands with at most concurrency of them
+// running at once (0 == the number of CPUs). Every command is run even
+// if some fail. The results are in the same order as the commands. If
+// any command fails, a *RunAllError is returned.
+func RunAll(cmds []*ExecCmd, concurrency int) ([]*ExecResult, error) {
+	return RunAllContext(context.Background(), cmds, concurrency, false)
+}
+
+// RunAllContext is RunAll with a context that applies to every command.
+// If failFast is true, the first failure cancels the commands that are
+// running and the commands not yet started are given ErrNotRun.
+func RunAllContext(ctx context.Context, cmds []*ExecCmd, concurrency int, failFast bool) ([]*ExecResult, error) {
+	var mu		sync.Mutex
+	var failed	bool
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]*ExecResult, len(cmds))
+
+	work := NewWorkQueue(
+		func(a interface{}, cmn interface{}) {
+			i := a.(int)
+			mu.Lock()
+			skip := failFast && failed
+			mu.Unlock()
+			if skip {
+				results[i] = &ExecResult{Args: cmds[i].Args(), ExitCode: -1, Err: ErrNotRun}
+				return
+			}
+			r, err := cmds[i].RunResultContext(ctx)
+			results[i] = r
+			if err != nil && failFast {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+				cancel()
+			}
+		},
+		nil,
+		concurrency)
+	for i := range cmds {
+		work.PushWork(i)
+	}
+	work.CloseAndWaitForCompletion()
+
+	e := &RunAllError{Cmds: cmds, Results: results}
+	for i, r := range results {
+		if r.Err == ErrNotRun {
+			e.NotRun = append(e.NotRun, i)
+		} else if r.Err != nil {
+			e.Failed = append(e.Failed, i)
+		}
+	}
+	if len(e.Failed) > 0 || len(e.NotRun) > 0 {
+		return results, e
+	}
+
+	return results, nil
+}
@@ -0,0 +1,99 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunAll(t *testing.T) {
+	var cmds	[]*ExecCmd
+
+	t.Log("TestRunAll()")
+
+	for i := 0; i < 6; i++ {
+		cmds = append(cmds, NewExecArgs("sh", "-c", "sleep 0.3; echo " + strconv.Itoa(i)))
+	}
+	start := time.Now()
+	results, err := RunAll(cmds, 3)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("RunAll() failed: %s\n", err.Error())
+	}
+	t.Logf("\t6 commands of 0.3s, 3 at a time, took %s\n", elapsed)
+	if elapsed < 550 * time.Millisecond || elapsed > 5 * time.Second {
+		t.Errorf("RunAll() concurrency was not limited to 3: %s\n", elapsed)
+	}
+	for i, r := range results {
+		if r.StdoutString() != strconv.Itoa(i) {
+			t.Errorf("result %d is out of order: %q\n", i, r.Stdout)
+		}
+	}
+
+	// Keep going after failures.
+	cmds = []*ExecCmd{
+		NewExecArgs("true"),
+		NewExecArgs("sh", "-c", "echo broken >&2; exit 4"),
+		NewExecArgs("echo", "ok"),
+		NewExecArgs("false"),
+	}
+	results, err = RunAll(cmds, 2)
+	var rae *RunAllError
+	if !errors.As(err, &rae) {
+		t.Fatalf("RunAll() error: %#v\n", err)
+	}
+	t.Logf("\t%s\n", err.Error())
+	if len(rae.Failed) != 2 || rae.Failed[0] != 1 || rae.Failed[1] != 3 || len(rae.NotRun) != 0 {
+		t.Errorf("RunAll() failed: %v not run: %v\n", rae.Failed, rae.NotRun)
+	}
+	if results[2].StdoutString() != "ok" || results[1].ExitCode != 4 {
+		t.Errorf("RunAll() results: %q %d\n", results[2].Stdout, results[1].ExitCode)
+	}
+	if !strings.Contains(err.Error(), "[1] Error: exec: sh -c 'echo broken >&2; exit 4': exit status 4") {
+		t.Errorf("RunAll() summary does not include the command: %s\n", err.Error())
+	}
+
+	t.Log("\tend: TestRunAll")
+}
+
+func TestRunAllFailFast(t *testing.T) {
+	var cmds	[]*ExecCmd
+
+	t.Log("TestRunAllFailFast()")
+
+	cmds = append(cmds, NewExecArgs("sleep", "10"), NewExecArgs("false"))
+	for i := 0; i < 5; i++ {
+		cmds = append(cmds, NewExecArgs("true"))
+	}
+	start := time.Now()
+	results, err := RunAllContext(context.Background(), cmds, 2, true)
+	if time.Since(start) > 5 * time.Second {
+		t.Errorf("RunAllContext() did not stop the running command: %s\n", time.Since(start))
+	}
+	var rae *RunAllError
+	if !errors.As(err, &rae) {
+		t.Fatalf("RunAllContext() error: %#v\n", err)
+	}
+	t.Logf("\t%s\n", err.Error())
+	if len(rae.NotRun) != 5 {
+		t.Errorf("RunAllContext() not run: %v\n", rae.NotRun)
+	}
+	for _, i := range rae.NotRun {
+		if results[i].Err != ErrNotRun || results[i].ExitCode != -1 {
+			t.Errorf("result %d: %v\n", i, results[i].Err)
+		}
+	}
+	if results[0].Err == nil || results[1].ExitCode != 1 {
+		t.Errorf("RunAllContext() results: %v %d\n", results[0].Err, results[1].ExitCode)
+	}
+
+	t.Log("\tend: TestRunAllFailFast")
+}
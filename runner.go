@@ -0,0 +1,350 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Runners
+
+// A CommandRunner performs the actual execution of an ExecCmd. The
+// OSRunner runs real processes and is used unless another runner is
+// attached to the command or its ExecOptions. The FakeRunner returns
+// canned responses so that code using ExecCmd can be unit tested
+// without running anything. The RecordingRunner captures executions
+// from another runner so that they can be saved as a JSON fixture and
+// later served back by a FakeRunner.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//============================================================================
+//                             Command Runner
+//============================================================================
+
+// CommandRunner runs the given command once writing its output to
+// stdout and stderr. It returns the exit code, -1 if the command did
+// not run to completion, and any error. A non-zero exit code must be
+// accompanied by an error.
+type CommandRunner interface {
+	RunCommand(ctx context.Context, c *ExecCmd, stdout, stderr io.Writer) (int, error)
+}
+
+// DefaultRunner is used by any ExecCmd that has no other runner.
+var DefaultRunner CommandRunner = OSRunner{}
+
+// ExitStatusError is returned by runners that do not run a real
+// process when the command's exit code is not zero.
+type ExitStatusError struct {
+	Code		int
+}
+
+func (e *ExitStatusError) Error( ) string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitStatusError) ExitCode( ) int {
+	return e.Code
+}
+
+// Runner returns the runner used to execute the command which is the
+// first of the command's runner, its options' runner or DefaultRunner.
+func (c *ExecCmd) Runner( ) CommandRunner {
+	if c.runner != nil {
+		return c.runner
+	}
+	if c.opts != nil {
+		if r := c.opts.Runner(); r != nil {
+			return r
+		}
+	}
+	return DefaultRunner
+}
+
+// SetRunner sets the runner used to execute the command.
+func (c *ExecCmd) SetRunner(r CommandRunner) *ExecCmd {
+	c.runner = r
+	return c
+}
+
+//============================================================================
+//                             Operating System
+//============================================================================
+
+// OSRunner runs the command as a real process. The command's exec.Cmd
+// is used as a template so that the command may be run more than once.
+type OSRunner struct {
+}
+
+func (OSRunner) RunCommand(ctx context.Context, c *ExecCmd, stdout, stderr io.Writer) (int, error) {
+	var err		error
+
+	in, closeIn, err := c.openStdin()
+	if err != nil {
+		return -1, err
+	}
+	defer func() { closeIn() }()
+	cmd := c.newOSCmd()
+	if in != nil {
+		cmd.Stdin = in
+	}
+	out, err := newOutputPipes(cmd, stdout, stderr)
+	if err != nil {
+		return -1, err
+	}
+	fwd := c.newSignalForwarder()
+	if err = c.startProcess(cmd); err == nil {
+		out.closeWriters()
+		// The command has its own copy of a file so ours is closed now.
+		// Otherwise a pipe's writer would block rather than fail once
+		// the command has gone.
+		if _, ok := in.(*os.File); ok {
+			closeIn()
+			closeIn = func() {}
+		}
+		fwd.Start(c, cmd)
+		err = c.wait(ctx, cmd)
+		out.wait((err != nil) && (ctx.Err() != nil || err == ErrTimeout))
+	} else {
+		out.close()
+	}
+	fwd.Stop()
+	// Leave the process information where it has always been.
+	c.cmd.Process, c.cmd.ProcessState = cmd.Process, cmd.ProcessState
+	if cmd.ProcessState == nil {
+		return -1, err
+	}
+
+	return cmd.ProcessState.ExitCode(), err
+}
+
+//============================================================================
+//                                 Fake
+//============================================================================
+
+// FakeResponse is a canned response returned by a FakeRunner for
+// matching commands. It is also the JSON fixture format.
+type FakeResponse struct {
+	Program		string		`json:"program"`
+	Args		[]string	`json:"args"`
+	AnyArgs		bool		`json:"any_args,omitempty"`	// Match any arguments
+	Stdout		string		`json:"stdout,omitempty"`
+	Stderr		string		`json:"stderr,omitempty"`
+	ExitCode	int			`json:"exit_code"`
+	Error		string		`json:"error,omitempty"`		// Failed to run
+	Times		int			`json:"times,omitempty"`		// Uses allowed, 0 == unlimited
+	used		int
+}
+
+// Fail makes the response a failure to run the command (such as the
+// program not being found) with the given error text.
+func (f *FakeResponse) Fail(msg string) *FakeResponse {
+	f.Error = msg
+	f.ExitCode = -1
+	return f
+}
+
+// Once limits the response to being used once.
+func (f *FakeResponse) Once( ) *FakeResponse {
+	f.Times = 1
+	return f
+}
+
+// Return sets the output and exit code of the response.
+func (f *FakeResponse) Return(stdout, stderr string, code int) *FakeResponse {
+	f.Stdout = stdout
+	f.Stderr = stderr
+	f.ExitCode = code
+	return f
+}
+
+// matches returns true if the response can be used for the arguments.
+func (f *FakeResponse) matches(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if (f.Times > 0) && (f.used >= f.Times) {
+		return false
+	}
+	if (f.Program != args[0]) && (f.Program != filepath.Base(args[0])) {
+		return false
+	}
+	if f.AnyArgs {
+		return true
+	}
+	if len(f.Args) != len(args)-1 {
+		return false
+	}
+	for i, a := range f.Args {
+		if a != args[i+1] {
+			return false
+		}
+	}
+	return true
+}
+
+// FakeRunner returns canned responses instead of running commands.
+// Responses are matched on the program and arguments in the order
+// that they were added. A response that has been used up is skipped
+// so that repeated commands can be given a sequence of responses.
+// A command without a matching response fails.
+type FakeRunner struct {
+	mu			sync.Mutex
+	calls		[][]string
+	responses	[]*FakeResponse
+}
+
+// Calls returns the arguments of every command run so far.
+func (f *FakeRunner) Calls( ) [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string{}, f.calls...)
+}
+
+// On adds a successful response without output for the program and
+// arguments which can then be adjusted.
+func (f *FakeRunner) On(program string, args ...string) *FakeResponse {
+	r := &FakeResponse{}
+	r.Program = program
+	r.Args = append([]string{}, args...)
+	f.mu.Lock()
+	f.responses = append(f.responses, r)
+	f.mu.Unlock()
+	return r
+}
+
+// OnAny adds a successful response without output for the program
+// with any arguments which can then be adjusted.
+func (f *FakeRunner) OnAny(program string) *FakeResponse {
+	r := f.On(program)
+	r.AnyArgs = true
+	return r
+}
+
+func (f *FakeRunner) RunCommand(ctx context.Context, c *ExecCmd, stdout, stderr io.Writer) (int, error) {
+	var resp	*FakeResponse
+
+	args := append([]string{}, c.cmd.Args...)
+	f.mu.Lock()
+	f.calls = append(f.calls, args)
+	for _, r := range f.responses {
+		if r.matches(args) {
+			r.used++
+			resp = r
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if resp == nil {
+		return -1, fmt.Errorf("Error: FakeRunner: no response for %s", c.CommandString())
+	}
+	if resp.Error != "" {
+		return -1, fmt.Errorf("%s", resp.Error)
+	}
+	io.WriteString(stdout, resp.Stdout)
+	io.WriteString(stderr, resp.Stderr)
+	if resp.ExitCode != 0 {
+		return resp.ExitCode, &ExitStatusError{Code: resp.ExitCode}
+	}
+
+	return 0, nil
+}
+
+//============================================================================
+//                               Recording
+//============================================================================
+
+// RecordingRunner runs commands with another runner and records each
+// execution so that it can be saved as a fixture for a FakeRunner.
+type RecordingRunner struct {
+	mu			sync.Mutex
+	inner		CommandRunner
+	recorded	[]*FakeResponse
+}
+
+// Recorded returns the executions recorded so far.
+func (r *RecordingRunner) Recorded( ) []*FakeResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*FakeResponse{}, r.recorded...)
+}
+
+func (r *RecordingRunner) RunCommand(ctx context.Context, c *ExecCmd, stdout, stderr io.Writer) (int, error) {
+	var outBuf	bytes.Buffer
+	var errBuf	bytes.Buffer
+
+	code, err := r.inner.RunCommand(ctx, c,
+					io.MultiWriter(stdout, &outBuf), io.MultiWriter(stderr, &errBuf))
+
+	resp := &FakeResponse{}
+	resp.Program = c.cmd.Args[0]
+	resp.Args = append([]string{}, c.cmd.Args[1:]...)
+	resp.Stdout = outBuf.String()
+	resp.Stderr = errBuf.String()
+	resp.ExitCode = code
+	resp.Times = 1
+	if (err != nil) && (code == -1) {
+		resp.Error = err.Error()
+	}
+	r.mu.Lock()
+	r.recorded = append(r.recorded, resp)
+	r.mu.Unlock()
+
+	return code, err
+}
+
+// Save writes the recorded executions to the given JSON fixture file.
+func (r *RecordingRunner) Save(p *Path) error {
+	text, err := json.MarshalIndent(r.Recorded(), "", "    ")
+	if err != nil {
+		return fmt.Errorf("Error: marshalling json: %s", err)
+	}
+	return ioutil.WriteFile(p.Absolute(), append(text, '\n'), 0644)
+}
+
+//----------------------------------------------------------------------------
+//							Class Functions
+//----------------------------------------------------------------------------
+
+func NewFakeRunner() *FakeRunner {
+	f := FakeRunner{}
+	return &f
+}
+
+// NewFakeRunnerFromFile creates a FakeRunner that replays the
+// executions in a JSON fixture file written by RecordingRunner.Save.
+// Each execution is served once in the order that it was recorded.
+func NewFakeRunnerFromFile(p *Path) (*FakeRunner, error) {
+	var responses	[]*FakeResponse
+
+	f := NewFakeRunner()
+	text, err := ioutil.ReadFile(p.Absolute())
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(text, &responses); err != nil {
+		return nil, fmt.Errorf("Error: unmarshalling json: %s : %s", err, p.String())
+	}
+	f.responses = responses
+
+	return f, nil
+}
+
+// NewRecordingRunner creates a runner that records the executions of
+// the given runner. If inner is nil, an OSRunner is used.
+func NewRecordingRunner(inner CommandRunner) *RecordingRunner {
+	r := RecordingRunner{}
+	if inner == nil {
+		inner = OSRunner{}
+	}
+	r.inner = inner
+	return &r
+}
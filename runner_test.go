@@ -0,0 +1,122 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFakeRunner(t *testing.T) {
+	var err 	error
+	var r		*ExecResult
+
+	t.Log("TestFakeRunner()")
+
+	fake := NewFakeRunner()
+	fake.On("ls", "-l").Return("file1\nfile2\n", "", 0)
+	fake.On("make").Return("", "failed\n", 2).Once()
+	fake.On("make").Return("built\n", "", 0)
+	fake.OnAny("git").Return("abc123\n", "", 0)
+	fake.On("missing").Fail("exec: \"missing\": executable file not found in $PATH")
+
+	r, err = NewExecArgs("ls", "-l").SetRunner(fake).RunResult()
+	if err != nil || r.StdoutString() != "file1\nfile2" {
+		t.Errorf("ls -l: %q %v\n", r.Stdout, err)
+	}
+
+	cmd := NewExecArgs("make").SetRunner(fake)
+	r, err = cmd.RunResult()
+	if err == nil || r.ExitCode != 2 || cmd.ExitCode() != 2 || r.StderrString() != "failed" {
+		t.Errorf("make (1st): %d %q %v\n", r.ExitCode, r.Stderr, err)
+	}
+	var ese *ExitStatusError
+	if !errors.As(err, &ese) || ese.ExitCode() != 2 {
+		t.Errorf("make (1st) error: %#v\n", err)
+	}
+	out, err := NewExecArgs("make").SetRunner(fake).RunWithOutput()
+	if err != nil || out != "built" {
+		t.Errorf("make (2nd): %q %v\n", out, err)
+	}
+
+	out, err = NewExecArgs("git", "rev-parse", "HEAD").SetRunner(fake).RunWithOutput()
+	if err != nil || out != "abc123" {
+		t.Errorf("git: %q %v\n", out, err)
+	}
+
+	r, err = NewExecArgs("missing").SetRunner(fake).RunResult()
+	if err == nil || r.ExitCode != -1 {
+		t.Errorf("missing: %d %v\n", r.ExitCode, err)
+	}
+
+	if err = NewExecArgs("ls", "-a").SetRunner(fake).Run(); err == nil {
+		t.Errorf("ls -a should not have matched!\n")
+	}
+
+	// Runners may also be supplied by the options.
+	opts := NewExecOptions().SetRunner(fake)
+	if err = NewExecArgs("ls", "-l").SetOptions(opts).Run(); err != nil {
+		t.Errorf("ls -l using options: %s\n", err.Error())
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 7 || calls[0][0] != "ls" || calls[0][1] != "-l" {
+		t.Errorf("Calls(): %q\n", calls)
+	}
+
+	t.Log("\tend: TestFakeRunner")
+}
+
+func TestRecordReplayRunner(t *testing.T) {
+	var err 	error
+
+	t.Log("TestRecordReplayRunner()")
+
+	fixture := NewTempDir().Append("runner_test_fixture.json")
+	defer fixture.DeleteFile()
+
+	// Record some real executions.
+	rec := NewRecordingRunner(nil)
+	pids := []string{}
+	for i := 0; i < 2; i++ {
+		out, err := NewExecArgs("sh", "-c", "echo $$").SetRunner(rec).RunWithOutput()
+		if err != nil {
+			t.Fatalf("sh -c 'echo $$' failed: %s\n", err.Error())
+		}
+		pids = append(pids, out)
+	}
+	r, _ := NewExecArgs("sh", "-c", "echo oops >&2; exit 3").SetRunner(rec).RunResult()
+	if r.ExitCode != 3 {
+		t.Errorf("recorded exit code: %d\n", r.ExitCode)
+	}
+	if err = rec.Save(fixture); err != nil {
+		t.Fatalf("Save(%s) failed: %s\n", fixture.String(), err.Error())
+	}
+	if len(rec.Recorded()) != 3 {
+		t.Errorf("Recorded(): %d\n", len(rec.Recorded()))
+	}
+
+	// Now replay them.
+	fake, err := NewFakeRunnerFromFile(fixture)
+	if err != nil {
+		t.Fatalf("NewFakeRunnerFromFile(%s) failed: %s\n", fixture.String(), err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		out, err := NewExecArgs("sh", "-c", "echo $$").SetRunner(fake).RunWithOutput()
+		if err != nil || out != pids[i] {
+			t.Errorf("replay %d: %q %v, but should be %q\n", i, out, err, pids[i])
+		}
+	}
+	r, err = NewExecArgs("sh", "-c", "echo oops >&2; exit 3").SetRunner(fake).RunResult()
+	if err == nil || r.ExitCode != 3 || r.StderrString() != "oops" {
+		t.Errorf("replay exit: %d %q %v\n", r.ExitCode, r.Stderr, err)
+	}
+	if err = NewExecArgs("sh", "-c", "echo $$").SetRunner(fake).Run(); err == nil {
+		t.Errorf("replay beyond the recording should have failed!\n")
+	}
+
+	t.Log("\tend: TestRecordReplayRunner")
+}
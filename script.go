@@ -0,0 +1,422 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Command Scripts
+
+// A Script runs a small "recipe" file of one command per line without
+// using a shell. Each line is split with ParseCommandLine and run as an
+// ExecCmd. The file may contain:
+//   - Blank lines and comments starting with an unquoted "#".
+//   - Lines continued onto the next line by a trailing backslash.
+//   - ${name} which is replaced by the SharedData definition of name
+//     unless it is within single quotes or escaped by a backslash. The
+//     value is always one argument or part of one; it is never split.
+//   - "set -e" to stop at the first command that fails (the default)
+//     and "set +e" to keep going and report all failures at the end.
+//   - "cd dir" to run the following lines in dir which is relative to
+//     the previous directory. "cd" alone returns to the first one.
+// The whole script is checked before any of it is run so that syntax
+// errors and undefined names do not leave a recipe half done. Errors
+// give the script's file and line number.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+//============================================================================
+//                             Script Errors
+//============================================================================
+
+// ScriptError describes a line of a script that could not be parsed or
+// whose command failed.
+type ScriptError struct {
+	Loc			Location		// Script file, line and (if known) column
+	Err			error			// Underlying error
+}
+
+// Error returns "Error: file:line[:col]: error".
+func (e *ScriptError) Error( ) string {
+	var b		strings.Builder
+
+	fmt.Fprintf(&b, "Error: %s:%d", e.Loc.Path, e.Loc.LineNo)
+	if e.Loc.ColNo > 0 {
+		fmt.Fprintf(&b, ":%d", e.Loc.ColNo)
+	}
+	fmt.Fprintf(&b, ": %s", strings.TrimPrefix(ErrorString(e.Err), "Error: "))
+	return b.String()
+}
+
+func (e *ScriptError) Unwrap( ) error {
+	return e.Err
+}
+
+// ScriptErrors collects the failures of a script that kept going after
+// its commands failed.
+type ScriptErrors struct {
+	Errors		[]*ScriptError
+}
+
+// Error lists each failure on its own line.
+func (e *ScriptErrors) Error( ) string {
+	var b		strings.Builder
+
+	fmt.Fprintf(&b, "Error: %d script commands failed:", len(e.Errors))
+	for _, se := range e.Errors {
+		fmt.Fprintf(&b, "\n\t%s", se.Error())
+	}
+	return b.String()
+}
+
+//============================================================================
+//                             Script Parsing
+//============================================================================
+
+// ScriptStep is a command of a script and its result once it has run.
+type ScriptStep struct {
+	Loc			Location		// Script file and line
+	Args		[]string		// Program and arguments
+	Dir			string			// Working directory
+	Result		*ExecResult		// nil == not run
+}
+
+// scriptLine is a line of a script after continuation lines have been
+// joined.
+type scriptLine struct {
+	lineNo		int				// 1-relative number of the first line
+	text		string
+}
+
+// joinScriptLines splits the text into lines joining those ending in
+// a backslash to the next one. The backslash-newline is kept since
+// ParseCommandLine removes it.
+func joinScriptLines(text string) []scriptLine {
+	var lines	[]scriptLine
+	var cur		*scriptLine
+
+	for i, l := range strings.Split(text, "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		if cur == nil {
+			lines = append(lines, scriptLine{lineNo: i+1})
+			cur = &lines[len(lines)-1]
+			cur.text = l
+		} else {
+			cur.text += "\n" + l
+		}
+		n := len(l) - len(strings.TrimRight(l, "\\"))
+		if n%2 == 0 {
+			cur = nil
+		}
+	}
+	return lines
+}
+
+// expandScriptLine removes any comment from the line and replaces each
+// ${name} with its value quoted for the context that it appears in.
+// The returned position is the offset in the line of any error.
+func expandScriptLine(line string, lookup func(string) (string, bool)) (string, int, error) {
+	var b		strings.Builder
+	var quote	rune				// 0, '\'' or '"'
+
+	blank := true					// Previous rune was an unescaped blank
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		afterBlank := blank
+		blank = false
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			}
+		case r == '\\':
+			b.WriteRune(r)
+			if i+1 < len(runes) {
+				i++
+				r = runes[i]
+			}
+		case (r == '$') && (i+1 < len(runes)) && (runes[i+1] == '{'):
+			end := i + 2
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end >= len(runes) {
+				return "", i, fmt.Errorf("Error: unterminated ${")
+			}
+			name := string(runes[i+2:end])
+			value, ok := lookup(name)
+			if !ok {
+				return "", i, fmt.Errorf("Error: %q is not defined", name)
+			}
+			if quote == '"' {
+				b.WriteString(escapeDoubleQuoted(value))
+			} else {
+				b.WriteString(QuoteArgPosix(value))
+			}
+			i = end
+			continue
+		case (r == '#') && (quote == 0) && afterBlank:
+			return b.String(), 0, nil
+		case (r == '\'') && (quote == 0):
+			quote = r
+		case r == '"':
+			if quote == 0 {
+				quote = r
+			} else {
+				quote = 0
+			}
+		case (quote == 0) && unicode.IsSpace(r):
+			blank = true
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), 0, nil
+}
+
+// escapeDoubleQuoted escapes the characters that are special within
+// double quotes.
+func escapeDoubleQuoted(s string) string {
+	var b		strings.Builder
+
+	for _, r := range s {
+		switch r {
+		case '$', '`', '"', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// lineLocation returns the location within the script of an offset in
+// the given line.
+func lineLocation(path string, line scriptLine, pos int) Location {
+	loc := Location{Path: path, Pos: pos, LineNo: line.lineNo, ColNo: 1}
+	for _, r := range []rune(line.text)[:pos] {
+		if r == '\n' {
+			loc.LineNo++
+			loc.ColNo = 1
+		} else {
+			loc.ColNo++
+		}
+	}
+	return loc
+}
+
+//============================================================================
+//                             Script
+//============================================================================
+
+// scriptCommand is a parsed line of a script.
+type scriptCommand struct {
+	loc			Location
+	args		[]string
+}
+
+// Script is a command script read from a file.
+type Script struct {
+	dir			*Path			// Initial working directory, nil == current
+	keepGoing	bool			// Initial mode is "set +e"
+	opts		*ExecOptions	// nil == none
+	output		io.Writer		// Commands' stdout and stderr, nil == discard
+	path		*Path
+	runner		CommandRunner	// nil == options' or DefaultRunner
+	shared		*SharedData		// ${name} definitions, nil == none
+	steps		[]*ScriptStep	// Commands of the last run
+}
+
+// SetDir sets the directory that the script starts in. If nil, it
+// starts in the current directory.
+func (s *Script) SetDir(p *Path) *Script {
+	s.dir = p
+	return s
+}
+
+// SetKeepGoing sets whether the script starts as if "set +e" were its
+// first line.
+func (s *Script) SetKeepGoing(f bool) *Script {
+	s.keepGoing = f
+	return s
+}
+
+// SetOptions sets the options given to every command (see ExecOptions).
+func (s *Script) SetOptions(o *ExecOptions) *Script {
+	s.opts = o
+	return s
+}
+
+// SetOutput sets where the commands' stdout and stderr are copied.
+func (s *Script) SetOutput(w io.Writer) *Script {
+	s.output = w
+	return s
+}
+
+// SetRunner sets the runner of every command (see CommandRunner).
+func (s *Script) SetRunner(r CommandRunner) *Script {
+	s.runner = r
+	return s
+}
+
+// Steps returns the commands run by the last run in the order that
+// they were run.
+func (s *Script) Steps( ) []*ScriptStep {
+	return s.steps
+}
+
+// parse reads the script returning its commands. "set" and "cd" lines
+// are returned as commands since they take effect when run.
+func (s *Script) parse( ) ([]scriptCommand, error) {
+	var cmds	[]scriptCommand
+
+	path := s.path.Absolute()
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &ScriptError{Loc: Location{Path: path}, Err: err}
+	}
+	lookup := func(name string) (string, bool) {
+		if s.shared == nil {
+			return "", false
+		}
+		v := s.shared.Defn(name)
+		if v == nil {
+			return "", false
+		}
+		return fmt.Sprintf("%v", v), true
+	}
+
+	for _, line := range joinScriptLines(string(text)) {
+		expanded, pos, err := expandScriptLine(line.text, lookup)
+		if err != nil {
+			return nil, &ScriptError{Loc: lineLocation(path, line, pos), Err: err}
+		}
+		args, err := ParseCommandLine(expanded)
+		if err != nil {
+			loc := Location{Path: path, LineNo: line.lineNo}
+			if cle, ok := err.(*CommandLineError); ok {
+				if expanded == line.text {
+					loc = lineLocation(path, line, cle.Loc.Pos)
+				}
+				err = fmt.Errorf("Error: %s", cle.Msg)
+			}
+			return nil, &ScriptError{Loc: loc, Err: err}
+		}
+		if len(args) == 0 {
+			continue
+		}
+		loc := Location{Path: path, LineNo: line.lineNo}
+		if (args[0] == "set") && ((len(args) != 2) || ((args[1] != "-e") && (args[1] != "+e"))) {
+			err = fmt.Errorf("Error: only \"set -e\" and \"set +e\" are supported")
+			return nil, &ScriptError{Loc: loc, Err: err}
+		}
+		if (args[0] == "cd") && (len(args) > 2) {
+			return nil, &ScriptError{Loc: loc, Err: fmt.Errorf("Error: cd: too many arguments")}
+		}
+		cmds = append(cmds, scriptCommand{loc, args})
+	}
+	return cmds, nil
+}
+
+// Run runs the script (see RunContext).
+func (s *Script) Run( ) error {
+	return s.RunContext(context.Background())
+}
+
+// RunContext checks the whole script and then runs its commands with
+// the given context. A line that cannot be parsed is returned as a
+// *ScriptError before anything is run. When stopping on errors, the
+// first failure is returned as a *ScriptError. If any commands failed
+// while keeping going, all of the failures are returned as a
+// *ScriptErrors.
+func (s *Script) RunContext(ctx context.Context) error {
+	var failed	[]*ScriptError
+	var output	io.Writer
+
+	s.steps = nil
+	cmds, err := s.parse()
+	if err != nil {
+		return err
+	}
+
+	start := s.dir
+	if start == nil {
+		start = NewCurrentWorkDir()
+	}
+	dir := NewPath(start.Absolute())
+	keepGoing := s.keepGoing
+	// stop returns the error ending the script along with any failures
+	// that were kept going after.
+	stop := func(se *ScriptError) error {
+		if len(failed) == 0 {
+			return se
+		}
+		return &ScriptErrors{Errors: append(failed, se)}
+	}
+	// Both stdout and stderr are copied to the output at the same time.
+	if s.output != nil {
+		output = &lockedWriter{w: s.output}
+	}
+
+	for _, sc := range cmds {
+		switch sc.args[0] {
+		case "set":
+			keepGoing = (sc.args[1] == "+e")
+			continue
+		case "cd":
+			next := start
+			if len(sc.args) == 2 {
+				next = NewPath(sc.args[1])
+				if !filepath.IsAbs(sc.args[1]) {
+					next = dir.Append(sc.args[1])
+				}
+			}
+			if !next.IsPathDir() {
+				err = fmt.Errorf("Error: cd: %s is not a directory", next.String())
+				return stop(&ScriptError{Loc: sc.loc, Err: err})
+			}
+			dir = NewPath(next.Absolute())
+			continue
+		}
+
+		if err = ctx.Err(); err != nil {
+			return stop(&ScriptError{Loc: sc.loc, Err: err})
+		}
+		step := &ScriptStep{Loc: sc.loc, Args: sc.args, Dir: dir.Absolute()}
+		s.steps = append(s.steps, step)
+		cmd := NewExecArgs(sc.args[0], sc.args[1:]...).Dir(dir)
+		if s.opts != nil {
+			cmd.SetOptions(s.opts)
+		}
+		if s.runner != nil {
+			cmd.SetRunner(s.runner)
+		}
+		if output != nil {
+			cmd.TeeStdout(output).TeeStderr(output)
+		}
+		step.Result, err = cmd.RunResultContext(ctx)
+		if err != nil {
+			se := &ScriptError{Loc: sc.loc, Err: err}
+			if !keepGoing {
+				return stop(se)
+			}
+			failed = append(failed, se)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &ScriptErrors{Errors: failed}
+	}
+	return nil
+}
+
+// NewScript returns a script that runs the file at the given path with
+// ${name} replaced by the definitions in sd (which may be nil).
+func NewScript(path *Path, sd *SharedData) *Script {
+	return &Script{path: path, shared: sd}
+}
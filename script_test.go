@@ -0,0 +1,175 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// writeScript writes a script to the temporary directory.
+func writeScript(t *testing.T, name, text string) *Path {
+	file := NewTempDir().Append(name)
+	if err := ioutil.WriteFile(file.Absolute(), []byte(text), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %s\n", err.Error())
+	}
+	return file
+}
+
+func TestScript(t *testing.T) {
+	var out		bytes.Buffer
+
+	t.Log("TestScript()")
+
+	sd := &SharedData{}
+	sd.Init()
+	sd.SetDefn("Name", "two words")
+	sd.SetDefn("Quote", `it's "quoted" $HOME`)
+	file := writeScript(t, "script_test.txt",
+		"# A recipe\n" +
+		"\n" +
+		"echo one   # trailing comment\n" +
+		"echo ${Name} 'single ${Name}' \"double ${Quote}\" \\${Name} a#b\n" +
+		"echo continued \\\n" +
+		"    line\n" +
+		"cd test\n" +
+		"pwd\n" +
+		"cd\n" +
+		"pwd\n")
+	defer file.DeleteFile()
+
+	s := NewScript(file, sd).SetOutput(&out)
+	if err := s.Run(); err != nil {
+		t.Fatalf("Run() failed: %s\n", err.Error())
+	}
+	steps := s.Steps()
+	if len(steps) != 5 {
+		t.Fatalf("Steps(): %d\n", len(steps))
+	}
+	want := []string{"echo", "two words", "single ${Name}", `double it's "quoted" $HOME`, "${Name}", "a#b"}
+	if !equalArgs(steps[1].Args, want) {
+		t.Errorf("expanded args: %q\n", steps[1].Args)
+	}
+	if steps[2].Loc.LineNo != 5 || !equalArgs(steps[2].Args, []string{"echo", "continued", "line"}) {
+		t.Errorf("continued line %d: %q\n", steps[2].Loc.LineNo, steps[2].Args)
+	}
+	cwd := NewCurrentWorkDir().Absolute()
+	if steps[3].Dir != NewPath("./test").Absolute() || steps[3].Result.StdoutString() != steps[3].Dir {
+		t.Errorf("cd test: %s %q\n", steps[3].Dir, steps[3].Result.Stdout)
+	}
+	if steps[4].Dir != cwd {
+		t.Errorf("cd: %s\n", steps[4].Dir)
+	}
+	if !strings.HasPrefix(out.String(), "one\ntwo words single") {
+		t.Errorf("output: %q\n", out.String())
+	}
+
+	// A "#" after an escaped blank is part of the argument.
+	writeScript(t, "script_test.txt", "echo a\\ #b c # comment\n")
+	s = NewScript(file, nil)
+	if err := s.Run(); err != nil || !equalArgs(s.Steps()[0].Args, []string{"echo", "a #b", "c"}) {
+		t.Errorf("escaped blank before #: %q %v\n", s.Steps()[0].Args, err)
+	}
+
+	t.Log("\tend: TestScript")
+}
+
+// cancelWriter cancels a context when it is written to.
+type cancelWriter context.CancelFunc
+
+func (w cancelWriter) Write(p []byte) (int, error) {
+	w()
+	return len(p), nil
+}
+
+func TestScriptOutput(t *testing.T) {
+	var out		bytes.Buffer
+
+	t.Log("TestScriptOutput()")
+
+	// Both streams of a failing command are written to the output.
+	file := writeScript(t, "script_test.txt",
+		"set +e\n" +
+		"sh -c 'i=0; while [ $i -lt 200 ]; do echo out; echo err >&2; i=$((i+1)); done; exit 1'\n")
+	defer file.DeleteFile()
+	err := NewScript(file, nil).SetOutput(&out).Run()
+	if err == nil || out.Len() != 200 * 8 {
+		t.Errorf("output: %d bytes %v\n", out.Len(), err)
+	}
+
+	t.Log("\tend: TestScriptOutput")
+}
+
+func TestScriptErrors(t *testing.T) {
+	var se		*ScriptError
+	var ses		*ScriptErrors
+
+	t.Log("TestScriptErrors()")
+
+	// Parse errors are found before anything is run.
+	file := writeScript(t, "script_test.txt", "echo ok\necho ${Missing}\n")
+	defer file.DeleteFile()
+	s := NewScript(file, nil)
+	err := s.Run()
+	if !errors.As(err, &se) || se.Loc.LineNo != 2 || se.Loc.ColNo != 6 || len(s.Steps()) != 0 {
+		t.Errorf("undefined name: %#v\n", err)
+	}
+	t.Logf("\t%s\n", ErrorString(err))
+	writeScript(t, "script_test.txt", "echo ok \\\n  'unterminated\n")
+	err = NewScript(file, nil).Run()
+	if !errors.As(err, &se) || se.Loc.LineNo != 2 || se.Loc.ColNo != 3 {
+		t.Errorf("unterminated quote: %#v\n", err)
+	}
+	t.Logf("\t%s\n", ErrorString(err))
+
+	// Stop on the first error.
+	writeScript(t, "script_test.txt", "echo a\nfalse\necho b\n")
+	s = NewScript(file, nil)
+	err = s.Run()
+	if !errors.As(err, &se) || se.Loc.LineNo != 2 || se.Loc.Path != file.Absolute() || len(s.Steps()) != 2 {
+		t.Errorf("set -e: %#v\n", err)
+	}
+	if !strings.HasPrefix(err.Error(), "Error: " + file.Absolute() + ":2: exec: false") {
+		t.Errorf("set -e message: %s\n", err.Error())
+	}
+
+	// Keep going and report all of the failures.
+	writeScript(t, "script_test.txt", "set +e\nfalse\necho b\nsh -c 'exit 3'\nset -e\nfalse\necho c\n")
+	s = NewScript(file, nil)
+	err = s.Run()
+	if !errors.As(err, &ses) || len(ses.Errors) != 3 || len(s.Steps()) != 4 {
+		t.Fatalf("set +e: %#v\n", err)
+	}
+	if ses.Errors[1].Loc.LineNo != 4 || ses.Errors[2].Loc.LineNo != 6 {
+		t.Errorf("set +e lines: %d %d\n", ses.Errors[1].Loc.LineNo, ses.Errors[2].Loc.LineNo)
+	}
+	t.Logf("\t%s\n", err.Error())
+
+	writeScript(t, "script_test.txt", "cd no_such_dir\necho a\n")
+	if err = NewScript(file, nil).Run(); !errors.As(err, &se) || se.Loc.LineNo != 1 {
+		t.Errorf("cd: %#v\n", err)
+	}
+
+	// Stopping for a bad directory or the context keeps earlier failures.
+	writeScript(t, "script_test.txt", "set +e\nfalse\ncd no_such_dir\necho a\n")
+	err = NewScript(file, nil).Run()
+	if !errors.As(err, &ses) || len(ses.Errors) != 2 || ses.Errors[1].Loc.LineNo != 3 {
+		t.Errorf("set +e then cd: %#v\n", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writeScript(t, "script_test.txt", "set +e\nsh -c 'echo stop; exit 1'\necho a\n")
+	err = NewScript(file, nil).SetOutput(cancelWriter(cancel)).RunContext(ctx)
+	if !errors.As(err, &ses) || len(ses.Errors) != 2 || !errors.Is(ses.Errors[1], context.Canceled) {
+		t.Errorf("set +e then cancelled: %#v\n", err)
+	}
+
+	t.Log("\tend: TestScriptErrors")
+}
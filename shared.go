@@ -88,6 +88,17 @@ func (s *SharedData) Defn(nm string) interface{} {
 	return nil
 }
 
+// Defns returns a new map of all of the definitions including
+// cmd, dataPath, mainPath and outDir.
+func (s *SharedData) Defns() map[string]interface{} {
+	m := map[string]interface{}{}
+	s.MergeTo(m, true)
+	for _, nm := range []string{"cmd", "dataPath", "mainPath", "outDir"} {
+		m[nm] = s.Defn(nm)
+	}
+	return m
+}
+
 func (s *SharedData) IsDefined(nm string) bool {
 	x := s.Defn(nm)
 	if x != nil {
@@ -21,6 +21,12 @@ func TestSharedData(t *testing.T) {
 	if b {
 		t.Errorf("Force() should be false, but is true!\n")
 	}
+	sd.SetDefn("x", 1)
+	sd.SetOutDir("/tmp")
+	m := sd.Defns()
+	if m["x"] != 1 || m["outDir"] != "/tmp" || m["Force"] != false {
+		t.Errorf("Defns() = %v\n", m)
+	}
 	t.Log("\tend: TestSharedData")
 }
 
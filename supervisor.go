@@ -0,0 +1,500 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Supervised Background Processes
+
+// A Supervisor runs helper commands, such as a stand-in database or a
+// file server, in the background while a program does its work. The
+// commands are started in the order that they were added and each must
+// pass its readiness check (a TCP port accepting connections, an HTTP
+// URL returning 200 or a line of its output matching) before the next
+// is started so that later commands may depend on earlier ones. A
+// command that exits while it is supervised is restarted with the
+// backoff of its restart policy which starts again once the command
+// has been ready or stayed up for a while. Stop terminates the commands
+// in the reverse order. Each command runs in its own process group so
+// that Ctrl-C does not reach it directly and stopping it also stops any
+// processes that it started. Programs should defer Stop after Start.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//============================================================================
+//                             Readiness Checks
+//============================================================================
+
+// ReadinessPollInterval is the time between readiness checks.
+var ReadinessPollInterval = 100 * time.Millisecond
+
+// SupervisedOutputLines is the number of lines of output kept for each
+// supervised command since it was last started.
+var SupervisedOutputLines = 1000
+
+// ReadinessCheck returns true once the process is ready for use. It is
+// called repeatedly until it does.
+type ReadinessCheck func(ctx context.Context, p *SupervisedProcess) bool
+
+// ReadyHTTP is ready once a GET of the URL returns status 200.
+func ReadyHTTP(url string) ReadinessCheck {
+	return func(ctx context.Context, p *SupervisedProcess) bool {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return false
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return false
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}
+}
+
+// ReadyLogLine is ready once a line of the process's output (stdout or
+// stderr) since it was last started matches the regular expression.
+func ReadyLogLine(re *regexp.Regexp) ReadinessCheck {
+	return func(ctx context.Context, p *SupervisedProcess) bool {
+		for _, l := range p.Output() {
+			if re.MatchString(l) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ReadyTCP is ready once a TCP connection to the address ("host:port")
+// can be made.
+func ReadyTCP(addr string) ReadinessCheck {
+	return func(ctx context.Context, p *SupervisedProcess) bool {
+		var d		net.Dialer
+
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+}
+
+//============================================================================
+//                             Restart Policy
+//============================================================================
+
+// DefaultRestartMaxDelay is the longest delay before a restart if the
+// policy does not give one.
+var DefaultRestartMaxDelay = time.Minute
+
+// RestartPolicy controls how a supervised process is restarted after
+// it exits. The delay grows with each restart in a row and goes back to
+// InitialDelay once a run has been stable, that is it passed its
+// readiness check (if it has one) or stayed up for ResetAfter.
+type RestartPolicy struct {
+	MaxRestarts		int				// Restarts in a row, 0 == unlimited
+	InitialDelay	time.Duration	// Delay before the first restart
+	MaxDelay		time.Duration	// Maximum delay, 0 == DefaultRestartMaxDelay
+	Multiplier		float64			// Delay growth, <= 1 == 2
+	Jitter			float64			// Random fraction (0-1) of the delay
+	ResetAfter		time.Duration	// Stable uptime, 0 == the maximum delay
+}
+
+// Delay returns the time to wait before the given restart in a row
+// (1-relative) including jitter.
+func (rp *RestartPolicy) Delay(restart int) time.Duration {
+	p := RetryPolicy{InitialDelay: rp.InitialDelay, MaxDelay: rp.maxDelay(),
+						Multiplier: rp.Multiplier, Jitter: rp.Jitter}
+	return p.Delay(restart)
+}
+
+// maxDelay returns the maximum delay applying the default.
+func (rp *RestartPolicy) maxDelay( ) time.Duration {
+	if rp.MaxDelay > 0 {
+		return rp.MaxDelay
+	}
+	return DefaultRestartMaxDelay
+}
+
+// stable returns true if a run which was ready or not and was up for
+// the given time resets the delay.
+func (rp *RestartPolicy) stable(ready bool, up time.Duration) bool {
+	reset := rp.ResetAfter
+	if reset <= 0 {
+		reset = rp.maxDelay()
+	}
+	return ready || (up >= reset)
+}
+
+// NewRestartPolicy returns a policy which restarts the process up to
+// the given number of times in a row (0 == unlimited) starting with
+// the given delay and doubling it each time with 10% jitter.
+func NewRestartPolicy(restarts int, delay time.Duration) *RestartPolicy {
+	rp := RestartPolicy{}
+	rp.MaxRestarts = restarts
+	rp.InitialDelay = delay
+	rp.Multiplier = 2
+	rp.Jitter = 0.1
+	return &rp
+}
+
+//============================================================================
+//                             Supervised Process
+//============================================================================
+
+// SupervisedProcess is a command run by a Supervisor.
+type SupervisedProcess struct {
+	mu			sync.Mutex
+	cancel		context.CancelFunc	// Stops the process, nil == not started
+	cmd			*ExecCmd
+	done		chan struct{}		// Closed when supervision has ended
+	failed		chan struct{}		// Closed when it will not be restarted
+	failures	int					// Restarts since the last stable run
+	lastErr		error				// Error of the last run that failed
+	name		string
+	output		[]string			// Lines since the last start
+	ready		ReadinessCheck		// nil == ready once started
+	readyCh		chan struct{}		// Closed once first ready
+	readyRun	int					// Last run that passed the readiness check
+	readyTimeout	time.Duration	// 0 == no limit other than Start's context
+	restart		*RestartPolicy		// nil == never restart
+	runs		int					// Times started
+	sup			*Supervisor
+}
+
+// addLine keeps a line of output.
+func (p *SupervisedProcess) addLine(l string) {
+	p.mu.Lock()
+	p.output = append(p.output, l)
+	if n := len(p.output) - SupervisedOutputLines; n > 0 {
+		p.output = append([]string{}, p.output[n:]...)
+	}
+	p.mu.Unlock()
+}
+
+// Cmd returns the command being supervised.
+func (p *SupervisedProcess) Cmd( ) *ExecCmd {
+	return p.cmd
+}
+
+// Err returns the error of the last run that failed or nil if none has.
+func (p *SupervisedProcess) Err( ) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastErr
+}
+
+// Name returns the name given to the process.
+func (p *SupervisedProcess) Name( ) string {
+	return p.name
+}
+
+// Output returns the lines of output since the process was last started.
+func (p *SupervisedProcess) Output( ) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.output...)
+}
+
+// Ready returns true once the process has first passed its readiness
+// check.
+func (p *SupervisedProcess) Ready( ) bool {
+	select {
+	case <-p.readyCh:
+		return true
+	default:
+	}
+	return false
+}
+
+// Restarts returns the number of times that the process was restarted.
+func (p *SupervisedProcess) Restarts( ) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.runs == 0 {
+		return 0
+	}
+	return p.runs - 1
+}
+
+// SetReadiness sets the check that must pass within the timeout (0 ==
+// no limit) before Start considers the process ready.
+func (p *SupervisedProcess) SetReadiness(check ReadinessCheck, timeout time.Duration) *SupervisedProcess {
+	p.ready = check
+	p.readyTimeout = timeout
+	return p
+}
+
+// SetRestartPolicy sets how the process is restarted after it exits.
+// nil never restarts it.
+func (p *SupervisedProcess) SetRestartPolicy(rp *RestartPolicy) *SupervisedProcess {
+	p.restart = rp
+	return p
+}
+
+// supervise runs the process until the context is done or it exits
+// and may not be restarted.
+func (p *SupervisedProcess) supervise(ctx context.Context) {
+	defer close(p.done)
+	for {
+		p.mu.Lock()
+		p.runs++
+		run := p.runs
+		p.output = nil
+		exited := make(chan struct{})
+		p.mu.Unlock()
+		p.sup.logf("supervisor: %s: start %d: %s", p.name, run, p.cmd.CommandString())
+
+		started := time.Now()
+		if p.ready == nil {
+			p.setReady(run)
+		} else {
+			go p.pollReady(ctx, run, exited)
+		}
+		r, _ := p.cmd.RunResultContext(ctx)
+		close(exited)
+		if ctx.Err() != nil {
+			p.sup.logf("supervisor: %s: stopped", p.name)
+			return
+		}
+
+		err := r.Err
+		if err == nil {
+			err = fmt.Errorf("Error: %s exited unexpectedly", p.name)
+		}
+		rp := p.restart
+		p.mu.Lock()
+		p.lastErr = err
+		if (rp != nil) && rp.stable((p.ready != nil) && (p.readyRun == run), time.Since(started)) {
+			p.failures = 0
+		}
+		p.failures++
+		failures := p.failures
+		p.mu.Unlock()
+		p.sup.logf("supervisor: %s: exited: %s", p.name, ErrorString(r.Err))
+
+		if (rp == nil) || ((rp.MaxRestarts > 0) && (failures > rp.MaxRestarts)) {
+			if rp != nil {
+				p.sup.logf("supervisor: %s: not restarted after %d restarts in a row", p.name, failures-1)
+			}
+			close(p.failed)
+			return
+		}
+		timer := time.NewTimer(rp.Delay(failures))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// pollReady runs the readiness check for the run until it passes, the
+// run ends or the context is done.
+func (p *SupervisedProcess) pollReady(ctx context.Context, run int, exited <-chan struct{}) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-exited:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(ReadinessPollInterval)
+	defer ticker.Stop()
+	for {
+		if p.ready(ctx, p) {
+			p.setReady(run)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// setReady marks the run of the process as having been ready.
+func (p *SupervisedProcess) setReady(run int) {
+	first := false
+	p.mu.Lock()
+	p.readyRun = run
+	select {
+	case <-p.readyCh:
+	default:
+		close(p.readyCh)
+		first = true
+	}
+	p.mu.Unlock()
+	// The supervisor's lock is never taken while holding ours.
+	if first {
+		p.sup.logf("supervisor: %s: ready", p.name)
+	}
+}
+
+// stop terminates the process and waits for supervision to end.
+func (p *SupervisedProcess) stop( ) {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-p.done
+}
+
+// waitReady waits for the process to be ready.
+func (p *SupervisedProcess) waitReady(ctx context.Context) error {
+	var timeout		<-chan time.Time
+
+	if p.readyTimeout > 0 {
+		timer := time.NewTimer(p.readyTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-p.readyCh:
+		return nil
+	case <-p.failed:
+		return fmt.Errorf("Error: %s exited before it was ready: %s", p.name, ErrorString(p.Err()))
+	case <-p.done:
+		return fmt.Errorf("Error: %s was stopped before it was ready", p.name)
+	case <-timeout:
+		return fmt.Errorf("Error: %s was not ready within %s; output: %q", p.name, p.readyTimeout,
+							strings.Join(tailLines(p.Output()), "\n"))
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tailLines returns the last ExecErrorTailLines lines.
+func tailLines(lines []string) []string {
+	if len(lines) > ExecErrorTailLines {
+		return lines[len(lines)-ExecErrorTailLines:]
+	}
+	return lines
+}
+
+//============================================================================
+//                             Supervisor
+//============================================================================
+
+// Supervisor runs several commands in the background.
+type Supervisor struct {
+	mu			sync.Mutex
+	log			io.Writer				// nil == no logging
+	procs		[]*SupervisedProcess
+	started		bool
+	stopped		bool					// No more processes may be started
+}
+
+// Add adds a command to be supervised. The command is put in its own
+// process group and its output is kept for readiness checks.
+func (s *Supervisor) Add(name string, cmd *ExecCmd) *SupervisedProcess {
+	p := &SupervisedProcess{name: name, cmd: cmd, sup: s}
+	p.done = make(chan struct{})
+	p.failed = make(chan struct{})
+	p.readyCh = make(chan struct{})
+	cmd.SetProcessGroup(true).OnStdoutLine(p.addLine).OnStderrLine(p.addLine)
+	s.mu.Lock()
+	s.procs = append(s.procs, p)
+	s.mu.Unlock()
+	return p
+}
+
+// logf writes a line to the log if there is one.
+func (s *Supervisor) logf(format string, a ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log != nil {
+		fmt.Fprintf(s.log, format + "\n", a...)
+	}
+}
+
+// Processes returns the supervised processes in the order added.
+func (s *Supervisor) Processes( ) []*SupervisedProcess {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*SupervisedProcess{}, s.procs...)
+}
+
+// SetLog sets where the starting, stopping and restarting of the
+// processes is logged.
+func (s *Supervisor) SetLog(w io.Writer) *Supervisor {
+	s.mu.Lock()
+	s.log = w
+	s.mu.Unlock()
+	return s
+}
+
+// Start starts the processes in the order that they were added waiting
+// for each to be ready before starting the next. The processes keep
+// running until Stop is called (not when the context is done). If a
+// process is not ready in time or exits without being restarted before
+// it is ready, the processes already started are stopped and an error
+// is returned.
+func (s *Supervisor) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return fmt.Errorf("Error: supervisor already started")
+	}
+	s.started = true
+	procs := append([]*SupervisedProcess{}, s.procs...)
+	s.mu.Unlock()
+
+	for _, p := range procs {
+		// Stop may be called at any time so it must either see the
+		// process as started or prevent it from being started.
+		s.mu.Lock()
+		if s.stopped {
+			s.mu.Unlock()
+			return fmt.Errorf("Error: supervisor stopped while starting")
+		}
+		pctx, cancel := context.WithCancel(context.Background())
+		p.mu.Lock()
+		p.cancel = cancel
+		p.mu.Unlock()
+		go p.supervise(pctx)
+		s.mu.Unlock()
+		if err := p.waitReady(ctx); err != nil {
+			s.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop terminates the processes in the reverse order that they were
+// started (see ExecCmd.SetGracePeriod) and waits for them to end.
+func (s *Supervisor) Stop( ) {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	procs := s.Processes()
+	for i := len(procs) - 1; i >= 0; i-- {
+		procs[i].stop()
+	}
+}
+
+// NewSupervisor returns a supervisor without any processes.
+func NewSupervisor() *Supervisor {
+	return &Supervisor{}
+}
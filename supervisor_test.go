@@ -0,0 +1,159 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupervisor(t *testing.T) {
+	var log		bytes.Buffer
+
+	t.Log("TestSupervisor()")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %s\n", err.Error())
+	}
+	defer ln.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	s := NewSupervisor().SetLog(&log)
+	db := s.Add("db", NewExecArgs("sh", "-c", "echo starting; sleep 0.2; echo listening; sleep 30")).
+			SetReadiness(ReadyLogLine(regexp.MustCompile(`^listening$`)), 5 * time.Second)
+	tcp := s.Add("tcp", NewExecArgs("sleep", "30")).SetReadiness(ReadyTCP(ln.Addr().String()), 5 * time.Second)
+	web := s.Add("web", NewExecArgs("sleep", "30")).SetReadiness(ReadyHTTP(srv.URL), 5 * time.Second)
+	start := time.Now()
+	if err = s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() failed: %s\n", err.Error())
+	}
+	if time.Since(start) < 200 * time.Millisecond {
+		t.Errorf("Start() did not wait for db to be ready\n")
+	}
+	if !db.Ready() || !tcp.Ready() || !web.Ready() {
+		t.Errorf("Ready(): %v %v %v\n", db.Ready(), tcp.Ready(), web.Ready())
+	}
+	if out := db.Output(); len(out) != 2 || out[1] != "listening" {
+		t.Errorf("Output(): %q\n", out)
+	}
+
+	s.Stop()
+	for _, p := range s.Processes() {
+		if r := p.Cmd().Result(); (r == nil) || (r.Signal != "terminated") {
+			t.Errorf("%s was not terminated: %#v\n", p.Name(), r)
+		}
+	}
+	text := log.String()
+	if !strings.Contains(text, "supervisor: db: ready") ||
+			(strings.Index(text, "web: stopped") > strings.Index(text, "db: stopped")) {
+		t.Errorf("log:\n%s\n", text)
+	}
+
+	t.Log("\tend: TestSupervisor")
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	t.Log("TestSupervisorRestart()")
+
+	file := NewTempDir().Append("supervisor_test_runs.txt")
+	file.DeleteFile()
+	defer file.DeleteFile()
+
+	// A process that keeps crashing is restarted until it may not be.
+	s := NewSupervisor()
+	p := s.Add("crash", NewExecArgs("sh", "-c", `echo run >> "$1"; sleep 0.1; exit 3`, "sh", file.Absolute())).
+			SetReadiness(ReadyLogLine(regexp.MustCompile(`never`)), 0).
+			SetRestartPolicy(NewRestartPolicy(2, 20 * time.Millisecond))
+	err := s.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("Start() should have failed: %v\n", err)
+	}
+	text, _ := ioutil.ReadFile(file.Absolute())
+	if p.Restarts() != 2 || strings.Count(string(text), "run") != 3 {
+		t.Errorf("Restarts(): %d %q\n", p.Restarts(), text)
+	}
+
+	// A process that is ready and then crashes is restarted. Since each
+	// run was ready, the restarts in a row start again every time.
+	file.DeleteFile()
+	s = NewSupervisor()
+	p = s.Add("flaky", NewExecArgs("sh", "-c", `echo run >> "$1"; echo up; sleep 0.2; exit 1`, "sh", file.Absolute())).
+			SetReadiness(ReadyLogLine(regexp.MustCompile(`^up$`)), 5 * time.Second).
+			SetRestartPolicy(NewRestartPolicy(1, 10 * time.Millisecond))
+	if err = s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() failed: %s\n", err.Error())
+	}
+	time.Sleep(700 * time.Millisecond)
+	s.Stop()
+	if p.Restarts() < 2 || p.Err() == nil {
+		t.Errorf("flaky Restarts(): %d %v\n", p.Restarts(), p.Err())
+	}
+
+	// Without a maximum delay, the delay is limited by the default.
+	rp := NewRestartPolicy(0, time.Second)
+	if d := rp.Delay(100); d <= 0 || d > DefaultRestartMaxDelay + DefaultRestartMaxDelay/10 {
+		t.Errorf("Delay(100) = %s\n", d)
+	}
+	if !rp.stable(false, DefaultRestartMaxDelay) || rp.stable(false, time.Second) || !rp.stable(true, 0) {
+		t.Errorf("stable() is wrong\n")
+	}
+
+	// A process that is never ready times out with its output.
+	s = NewSupervisor()
+	s.Add("slow", NewExecArgs("sh", "-c", "echo waiting; sleep 30")).
+			SetReadiness(ReadyLogLine(regexp.MustCompile(`never`)), 300 * time.Millisecond)
+	err = s.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "waiting") {
+		t.Errorf("Start() should have timed out: %v\n", err)
+	}
+	t.Logf("\t%s\n", ErrorString(err))
+
+	t.Log("\tend: TestSupervisorRestart")
+}
+
+func TestSupervisorStopWhileStarting(t *testing.T) {
+
+	t.Log("TestSupervisorStopWhileStarting()")
+
+	// Stop may be called while Start is waiting for a process to be
+	// ready. Start returns and no process is left running.
+	s := NewSupervisor()
+	for _, name := range []string{"a", "b", "c"} {
+		s.Add(name, NewExecArgs("sleep", "30")).SetReadiness(ReadyLogLine(regexp.MustCompile(`never`)), 0)
+	}
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+	select {
+	case err := <-started:
+		if err == nil {
+			t.Errorf("Start() should have failed\n")
+		}
+		t.Logf("\t%s\n", ErrorString(err))
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Start() did not return after Stop()\n")
+	}
+	for _, p := range s.Processes() {
+		if r := p.Cmd().Result(); (p.Name() == "a") != (r != nil) {
+			t.Errorf("%s: %#v\n", p.Name(), r)
+		}
+	}
+
+	t.Log("\tend: TestSupervisorStopWhileStarting")
+}
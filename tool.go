@@ -0,0 +1,558 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Required Tool Discovery
+
+// Generators depend on external programs which may be missing or too
+// old. A ToolChecker finds each required program on the PATH, runs it
+// to obtain its version, checks the version against a semantic version
+// constraint and reports every problem found in one error so that they
+// can all be fixed at once rather than one failed run at a time.
+
+// Constraints are made of comparisons such as ">=1.2", "<2", "!=1.4.1",
+// "^1.2" (same major version) and "~1.2.3" (same minor version). The
+// comparisons in a group are separated by spaces or commas and must all
+// be satisfied. Groups are separated by "||" and only one must be
+// satisfied. A version without an operator must match exactly in the
+// parts given so "1.2" is satisfied by 1.2.0 and 1.2.7.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//============================================================================
+//                             Version
+//============================================================================
+
+// Version is a semantic version. Missing minor and patch numbers are
+// zero.
+type Version struct {
+	Major		int
+	Minor		int
+	Patch		int
+	Pre			string			// Pre-release, "" == none
+	Build		string			// Build metadata which is ignored when comparing
+}
+
+var versionRegexp = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+
+// ParseVersion parses a version such as "1", "1.2", "v1.2.3" or
+// "1.2.3-rc.1+build.5".
+func ParseVersion(s string) (Version, error) {
+	v, _, err := parseVersionParts(s)
+	return v, err
+}
+
+// parseVersionParts parses the version also returning how many of its
+// numbers were given.
+func parseVersionParts(s string) (Version, int, error) {
+	var v		Version
+
+	m := versionRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return v, 0, fmt.Errorf("Error: invalid version: %q", s)
+	}
+	n := 0
+	for i, p := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if m[i+1] == "" {
+			break
+		}
+		x, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return v, 0, fmt.Errorf("Error: invalid version: %q", s)
+		}
+		*p = x
+		n++
+	}
+	v.Pre = m[4]
+	v.Build = m[5]
+	return v, n, nil
+}
+
+// Compare returns -1, 0 or 1 if v is less than, equal to or greater
+// than o using semantic version precedence.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return comparePrerelease(v.Pre, o.Pre)
+}
+
+// comparePrerelease compares pre-release identifiers. A version without
+// one has precedence over a version with one. Numeric identifiers
+// compare numerically and have lower precedence than others.
+func comparePrerelease(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// String returns the version as "major.minor.patch[-pre][+build]".
+func (v Version) String( ) string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+//============================================================================
+//                             Version Constraint
+//============================================================================
+
+// versionTerm is one comparison of a constraint.
+type versionTerm struct {
+	op			string
+	version		Version
+	parts		int				// Numbers given in the version
+}
+
+// check returns true if v satisfies the comparison.
+func (t versionTerm) check(v Version) bool {
+	c := v.Compare(t.version)
+	switch t.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "!=":
+		return !t.matches(v)
+	case "^":
+		if c < 0 {
+			return false
+		}
+		if t.version.Major != 0 || t.parts == 1 {
+			return v.Major == t.version.Major
+		}
+		if t.version.Minor != 0 || t.parts == 2 {
+			return v.Major == 0 && v.Minor == t.version.Minor
+		}
+		return c == 0
+	case "~":
+		if c < 0 {
+			return false
+		}
+		if t.parts == 1 {
+			return v.Major == t.version.Major
+		}
+		return v.Major == t.version.Major && v.Minor == t.version.Minor
+	}
+	return t.matches(v)
+}
+
+// matches returns true if v is equal to the version in the parts given.
+func (t versionTerm) matches(v Version) bool {
+	if t.parts < 3 {
+		if v.Major != t.version.Major {
+			return false
+		}
+		return (t.parts < 2) || (v.Minor == t.version.Minor)
+	}
+	return v.Compare(t.version) == 0
+}
+
+// VersionConstraint is a parsed constraint (see the top of this file).
+type VersionConstraint struct {
+	str			string
+	groups		[][]versionTerm	// Any group, all terms within it
+}
+
+// ParseVersionConstraint parses a constraint such as ">=1.2, <2 || ^3".
+// An empty constraint is satisfied by every version.
+func ParseVersionConstraint(s string) (*VersionConstraint, error) {
+	c := &VersionConstraint{str: strings.TrimSpace(s)}
+	if c.str == "" {
+		return c, nil
+	}
+	for _, g := range strings.Split(c.str, "||") {
+		var terms	[]versionTerm
+		words := strings.Fields(strings.Replace(g, ",", " ", -1))
+		for i := 0; i < len(words); i++ {
+			w := words[i]
+			op := ""
+			for _, o := range []string{">=", "<=", "!=", "==", ">", "<", "=", "^", "~"} {
+				if strings.HasPrefix(w, o) {
+					op = o
+					break
+				}
+			}
+			w = w[len(op):]
+			if (w == "") && (i+1 < len(words)) {
+				i++
+				w = words[i]
+			}
+			if op == "==" {
+				op = "="
+			}
+			v, n, err := parseVersionParts(w)
+			if err != nil {
+				return nil, fmt.Errorf("Error: invalid version constraint %q: %s", s, err.Error())
+			}
+			terms = append(terms, versionTerm{op: op, version: v, parts: n})
+		}
+		if len(terms) == 0 {
+			return nil, fmt.Errorf("Error: invalid version constraint %q: empty alternative", s)
+		}
+		c.groups = append(c.groups, terms)
+	}
+	return c, nil
+}
+
+// Check returns true if the version satisfies the constraint.
+func (c *VersionConstraint) Check(v Version) bool {
+	if len(c.groups) == 0 {
+		return true
+	}
+	for _, g := range c.groups {
+		ok := true
+		for _, t := range g {
+			if !t.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the constraint as it was given.
+func (c *VersionConstraint) String( ) string {
+	return c.str
+}
+
+//============================================================================
+//                             Tool Errors
+//============================================================================
+
+// ErrToolNotFound is wrapped by a ToolError when the program could not be
+// found on the PATH.
+var ErrToolNotFound = errors.New("tool not found")
+
+// ErrToolOutdated is wrapped by a ToolError when the program's version
+// does not satisfy its constraint.
+var ErrToolOutdated = errors.New("tool version does not satisfy constraint")
+
+// ToolError describes a required tool that is missing, could not be
+// run, or has an unacceptable version.
+type ToolError struct {
+	Name		string			// Name as required
+	Path		string			// Executable found, "" == none
+	Searched	[]string		// Directories searched when not found
+	Candidates	[]string		// Files found that are not executable
+	Version		string			// Version found, "" == unknown
+	Constraint	string			// Version constraint required
+	Err			error			// Underlying error
+}
+
+// Error returns a one line description of the problem.
+func (e *ToolError) Error( ) string {
+	var b		strings.Builder
+
+	fmt.Fprintf(&b, "Error: tool %s", e.Name)
+	if e.Path != "" && e.Path != e.Name {
+		fmt.Fprintf(&b, " (%s)", e.Path)
+	}
+	fmt.Fprintf(&b, ": %s", ErrorString(e.Err))
+	if (e.Path != "") && (e.Version != "" || e.Constraint != "") {
+		fmt.Fprintf(&b, " (have %s, need %s)", orUnknown(e.Version), e.Constraint)
+	}
+	if len(e.Candidates) > 0 {
+		fmt.Fprintf(&b, "; not executable: %s", strings.Join(e.Candidates, ", "))
+	} else if e.Path == "" && len(e.Searched) > 0 {
+		fmt.Fprintf(&b, "; searched: %s", strings.Join(e.Searched, string(os.PathListSeparator)))
+	}
+	return b.String()
+}
+
+func (e *ToolError) Unwrap( ) error {
+	return e.Err
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// ToolsError collects the problems of every tool that failed its
+// requirements.
+type ToolsError struct {
+	Tools		[]*ToolError
+}
+
+// Error lists each tool's problem on its own line.
+func (e *ToolsError) Error( ) string {
+	var b		strings.Builder
+
+	fmt.Fprintf(&b, "Error: %d required tools are missing or unusable:", len(e.Tools))
+	for _, t := range e.Tools {
+		fmt.Fprintf(&b, "\n\t%s", t.Error())
+	}
+	return b.String()
+}
+
+//============================================================================
+//                             Tool Checker
+//============================================================================
+
+// DefaultToolVersionRegexp extracts the first version number from a
+// tool's output such as "1.27.1" from "go version go1.27.1 linux/amd64".
+var DefaultToolVersionRegexp = regexp.MustCompile(`\d+(?:\.\d+){1,2}(?:-[0-9A-Za-z.-]+)?`)
+
+// ToolVersionTimeout is the maximum time allowed for a tool to report
+// its version.
+var ToolVersionTimeout = 10 * time.Second
+
+// Tool is a required tool that was found.
+type Tool struct {
+	Name		string			// Name as required
+	Path		string			// Executable found
+	Version		string			// Version reported, "" == not asked
+}
+
+// toolRequirement is one tool required by a ToolChecker.
+type toolRequirement struct {
+	name		string
+	versionArgs	[]string
+	constraint	string
+	re			*regexp.Regexp
+}
+
+// ToolChecker checks a set of required tools.
+type ToolChecker struct {
+	reqs		[]toolRequirement
+	re			*regexp.Regexp	// Version regexp for the next requirements
+	runner		CommandRunner	// nil == DefaultRunner
+}
+
+// Require adds a required tool. If versionArgs or constraint is given,
+// the tool is run with versionArgs (default "--version") and its version
+// is taken from its output and checked against the constraint.
+func (tc *ToolChecker) Require(name string, versionArgs []string, constraint string) *ToolChecker {
+	tc.reqs = append(tc.reqs, toolRequirement{name, versionArgs, constraint, tc.re})
+	return tc
+}
+
+// SetRunner sets the runner used to run the tools to obtain their
+// versions (see CommandRunner).
+func (tc *ToolChecker) SetRunner(r CommandRunner) *ToolChecker {
+	tc.runner = r
+	return tc
+}
+
+// SetVersionRegexp sets the regexp used to find the version in the
+// output of the tools required after it. If it has a subexpression,
+// the first one is the version, otherwise the whole match is. nil
+// selects DefaultToolVersionRegexp.
+func (tc *ToolChecker) SetVersionRegexp(re *regexp.Regexp) *ToolChecker {
+	tc.re = re
+	return tc
+}
+
+// Check checks every required tool returning those found in the order
+// they were required. If any are missing or unusable, a *ToolsError
+// describing all of them is returned.
+func (tc *ToolChecker) Check( ) ([]*Tool, error) {
+	var tools	[]*Tool
+	var errs	[]*ToolError
+
+	for _, req := range tc.reqs {
+		t, err := tc.check(req)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		tools = append(tools, t)
+	}
+	if len(errs) > 0 {
+		return tools, &ToolsError{Tools: errs}
+	}
+	return tools, nil
+}
+
+// check checks one required tool.
+func (tc *ToolChecker) check(req toolRequirement) (*Tool, *ToolError) {
+	var vc		*VersionConstraint
+	var err		error
+
+	te := &ToolError{Name: req.name, Constraint: req.constraint}
+	if vc, err = ParseVersionConstraint(req.constraint); err != nil {
+		te.Err = err
+		return nil, te
+	}
+
+	path, err := exec.LookPath(req.name)
+	if err != nil {
+		te.Err = ErrToolNotFound
+		if !errors.Is(err, exec.ErrNotFound) {
+			te.Err = fmt.Errorf("%w: %s", ErrToolNotFound, err.Error())
+		}
+		te.Searched, te.Candidates = lookPathDiagnostics(req.name)
+		return nil, te
+	}
+	te.Path = path
+	t := &Tool{Name: req.name, Path: path}
+	if req.versionArgs == nil && req.constraint == "" {
+		return t, nil
+	}
+
+	args := req.versionArgs
+	if args == nil {
+		args = []string{"--version"}
+	}
+	cmd := NewExecArgs(path, args...).SetTimeout(ToolVersionTimeout)
+	if tc.runner != nil {
+		cmd.SetRunner(tc.runner)
+	}
+	r, err := cmd.RunResult()
+	if err != nil {
+		te.Err = err
+		return nil, te
+	}
+	re := req.re
+	if re == nil {
+		re = DefaultToolVersionRegexp
+	}
+	t.Version = findToolVersion(re, r.Stdout)
+	if t.Version == "" {
+		t.Version = findToolVersion(re, r.Stderr)
+	}
+	if t.Version == "" {
+		te.Err = fmt.Errorf("no version found in output of %s", cmd.CommandString())
+		return nil, te
+	}
+	te.Version = t.Version
+
+	v, err := ParseVersion(t.Version)
+	if err != nil {
+		te.Err = err
+		return nil, te
+	}
+	if !vc.Check(v) {
+		te.Err = ErrToolOutdated
+		return nil, te
+	}
+	return t, nil
+}
+
+// findToolVersion returns the version found by the regexp in the output.
+func findToolVersion(re *regexp.Regexp, output []byte) string {
+	m := re.FindSubmatch(output)
+	if m == nil {
+		return ""
+	}
+	if len(m) > 1 {
+		return string(m[1])
+	}
+	return string(m[0])
+}
+
+// lookPathDiagnostics returns the directories that LookPath searched for
+// the program and any files of that name which are not executable.
+func lookPathDiagnostics(name string) ([]string, []string) {
+	var candidates	[]string
+
+	if strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		if fi, err := os.Stat(name); err == nil && !fi.IsDir() {
+			candidates = append(candidates, name)
+		}
+		return nil, candidates
+	}
+	dirs := filepath.SplitList(os.Getenv("PATH"))
+	names := []string{name}
+	if runtime.GOOS == "windows" && filepath.Ext(name) == "" {
+		for _, ext := range filepath.SplitList(os.Getenv("PATHEXT")) {
+			names = append(names, name+strings.ToLower(ext))
+		}
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			dir = "."
+		}
+		for _, n := range names {
+			file := filepath.Join(dir, n)
+			if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+				candidates = append(candidates, file)
+			}
+		}
+	}
+	return dirs, candidates
+}
+
+// NewToolChecker returns a checker without any required tools.
+func NewToolChecker() *ToolChecker {
+	return &ToolChecker{}
+}
+
+// RequireTool checks one required tool (see ToolChecker.Require). If it
+// is missing or unusable, a *ToolError is returned.
+func RequireTool(name string, versionArgs []string, constraint string) (*Tool, error) {
+	t, te := NewToolChecker().check(toolRequirement{name: name, versionArgs: versionArgs, constraint: constraint})
+	if te != nil {
+		return nil, te
+	}
+	return t, nil
+}
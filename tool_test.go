@@ -0,0 +1,149 @@
+// vi:nu:et:sts=4 ts=4 sw=4
+// See License.txt in main repository directory
+
+// Test files package
+
+package util
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint	string
+		version		string
+		ok			bool
+	}{
+		{"", "0.0.1", true},
+		{">=1.2", "1.2.0", true},
+		{">=1.2", "1.1.9", false},
+		{">= 1.2, <2", "1.9.9", true},
+		{">=1.2 <2", "2.0.0", false},
+		{">1.2.3", "1.2.3", false},
+		{"<=1.2.3", "1.2.3", true},
+		{"1.2", "1.2.7", true},
+		{"=1.2", "1.3.0", false},
+		{"==1.2.3", "1.2.3", true},
+		{"!=1.4", "1.4.2", false},
+		{"!=1.4.1", "1.4.2", true},
+		{"^1.2", "1.9.0", true},
+		{"^1.2", "2.0.0", false},
+		{"^1.2", "1.1.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.8.0", true},
+		{"<1 || >=3", "3.1.0", true},
+		{"<1 || >=3", "2.0.0", false},
+		{">=1.2.3", "1.2.3-rc.1", false},
+		{">=1.2.3-rc.2", "1.2.3-rc.10", true},
+		{">=1.2.3-beta", "1.2.3-alpha", false},
+		{"v1.2", "v1.2.0+build.1", true},
+	}
+
+	t.Log("TestVersionConstraint()")
+
+	for _, tt := range tests {
+		c, err := ParseVersionConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseVersionConstraint(%q): %s\n", tt.constraint, err.Error())
+			continue
+		}
+		v, err := ParseVersion(tt.version)
+		if err != nil {
+			t.Errorf("ParseVersion(%q): %s\n", tt.version, err.Error())
+			continue
+		}
+		if c.Check(v) != tt.ok {
+			t.Errorf("%q.Check(%q) should be %v\n", tt.constraint, tt.version, tt.ok)
+		}
+	}
+
+	for _, s := range []string{">=", "1.x", ">=1 ||", "abc"} {
+		if _, err := ParseVersionConstraint(s); err == nil {
+			t.Errorf("ParseVersionConstraint(%q) should have failed\n", s)
+		}
+	}
+	if v, _ := ParseVersion("v2"); v.String() != "2.0.0" {
+		t.Errorf("ParseVersion(v2): %s\n", v.String())
+	}
+
+	t.Log("\tend: TestVersionConstraint")
+}
+
+func TestRequireTool(t *testing.T) {
+	var te		*ToolError
+	var tse		*ToolsError
+
+	t.Log("TestRequireTool()")
+
+	dir := NewTempDir().Append("tool_test")
+	dir.RemoveDir()
+	if err := dir.CreateDir(); err != nil {
+		t.Fatalf("CreateDir() failed: %s\n", err.Error())
+	}
+	defer dir.RemoveDir()
+	script := "#!/bin/sh\necho \"mytool version 1.4.2 (built today)\"\necho \"compat 9.9\" >&2\n"
+	if err := ioutil.WriteFile(dir.Append("mytool").Absolute(), []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() failed: %s\n", err.Error())
+	}
+	if err := ioutil.WriteFile(dir.Append("brokentool").Absolute(), []byte(script), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %s\n", err.Error())
+	}
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir.Absolute() + string(os.PathListSeparator) + path)
+
+	tool, err := RequireTool("mytool", nil, ">=1.4, <2")
+	if err != nil {
+		t.Fatalf("RequireTool(mytool) failed: %s\n", err.Error())
+	}
+	if tool.Version != "1.4.2" || tool.Path != dir.Append("mytool").Absolute() {
+		t.Errorf("RequireTool(mytool): %#v\n", tool)
+	}
+
+	_, err = RequireTool("mytool", []string{"-v"}, "^2")
+	if !errors.As(err, &te) || !errors.Is(err, ErrToolOutdated) || te.Version != "1.4.2" {
+		t.Errorf("RequireTool(mytool, ^2): %#v\n", err)
+	}
+
+	_, err = RequireTool("brokentool", nil, "")
+	if !errors.As(err, &te) || !errors.Is(err, ErrToolNotFound) {
+		t.Fatalf("RequireTool(brokentool): %#v\n", err)
+	}
+	if len(te.Candidates) != 1 || te.Candidates[0] != dir.Append("brokentool").Absolute() {
+		t.Errorf("RequireTool(brokentool) candidates: %q\n", te.Candidates)
+	}
+	t.Logf("\t%s\n", err.Error())
+
+	tc := NewToolChecker().
+			Require("sh", nil, "").
+			Require("no_such_tool_xyz", nil, ">=1").
+			Require("mytool", nil, "<1.4").
+			SetVersionRegexp(regexp.MustCompile(`compat (\d+\.\d+)`)).
+			Require("mytool", nil, ">=9")
+	tools, err := tc.Check()
+	if len(tools) != 2 || tools[0].Name != "sh" || tools[1].Version != "9.9" {
+		t.Errorf("Check() tools: %d\n", len(tools))
+	}
+	if !errors.As(err, &tse) || len(tse.Tools) != 2 {
+		t.Fatalf("Check() error: %#v\n", err)
+	}
+	if tse.Tools[0].Name != "no_such_tool_xyz" || len(tse.Tools[0].Searched) == 0 {
+		t.Errorf("Check() missing tool: %#v\n", tse.Tools[0])
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "no_such_tool_xyz") || !strings.Contains(msg, "have 1.4.2, need <1.4") {
+		t.Errorf("Check() error message: %s\n", msg)
+	}
+	t.Logf("\t%s\n", msg)
+
+	t.Log("\tend: TestRequireTool")
+}